@@ -0,0 +1,96 @@
+package rq
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Annotations are given as comments before the request line, ex., `# @timeout 5s`.
+const (
+	// AnnotationName sets the name of the request.
+	AnnotationName = "name"
+	// AnnotationNoRedirect prevents redirects from being followed.
+	AnnotationNoRedirect = "no-redirect"
+	// AnnotationNoCookieJar prevents cookies from being stored or sent.
+	AnnotationNoCookieJar = "no-cookie-jar"
+	// AnnotationTimeout sets the timeout of the request, either a duration such as `500ms`
+	// or a number of seconds.
+	AnnotationTimeout = "timeout"
+	// AnnotationTag adds one or more tags to the request, tags are collected in Request.Tags.
+	AnnotationTag = "tag"
+	// AnnotationNoLint disables the given comma separated lint rules for the request,
+	// or all of them when no rule is given, see Lint.
+	AnnotationNoLint = "no-lint"
+)
+
+// HasAnnotation reports whether the request is annotated with key.
+func (r Request) HasAnnotation(key string) bool {
+	_, ok := r.Annotations[key]
+	return ok
+}
+
+// HasTag reports whether the request is tagged with any of the tags.
+func (r Request) HasTag(tags ...string) bool {
+	for _, tag := range tags {
+		for _, t := range r.Tags {
+			if t == tag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func parseTimeout(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid @%s %q", AnnotationTimeout, value)
+	}
+	return timeout, nil
+}
+
+// applyAnnotations returns the runner and context used to execute the request. The
+// no-redirect and no-cookie-jar annotations are only honoured when the runner is an
+// *http.Client. The returned cancel func must be called once the response is not used.
+func (r Request) applyAnnotations(ctx context.Context, runner RequestRunner) (context.Context, RequestRunner, context.CancelFunc, error) {
+	cancel := context.CancelFunc(func() {})
+	if value, ok := r.Annotations[AnnotationTimeout]; ok {
+		timeout, err := parseTimeout(value)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+		}
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	client, ok := runner.(*http.Client)
+	if !ok || !(r.HasAnnotation(AnnotationNoRedirect) || r.HasAnnotation(AnnotationNoCookieJar)) {
+		return ctx, runner, cancel, nil
+	}
+	configured := *client
+	if r.HasAnnotation(AnnotationNoRedirect) {
+		configured.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+	if r.HasAnnotation(AnnotationNoCookieJar) {
+		configured.Jar = nil
+	}
+	return ctx, &configured, cancel, nil
+}
+
+// cancelOnClose releases the context of a request once its response body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
@@ -0,0 +1,236 @@
+// Package ast declares the types used to represent the syntax tree of .http files
+// and the parser producing it.
+//
+// The tree is lossless: every line of a file belongs to exactly one node and each
+// node keeps its exact source, including comments, blank lines and line endings, so
+// concatenating the Raw text of all nodes reproduces the file byte for byte. Every
+// node records the byte offsets it spans in the file.
+package ast
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Pos is a 0-based byte offset in a file.
+type Pos int
+
+// Position describes a location in a file in a human-readable form.
+type Position struct {
+	Offset int // 0-based byte offset
+	Line   int // 1-based line number
+	Column int // 1-based column, in bytes
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// Node is implemented by all the nodes of the tree.
+type Node interface {
+	// Pos returns the offset of the first byte of the node.
+	Pos() Pos
+	// End returns the offset of the byte following the node.
+	End() Pos
+}
+
+// Span is the range of bytes a node covers, From is inclusive and To exclusive.
+type Span struct {
+	From Pos
+	To   Pos
+}
+
+func (s Span) Pos() Pos { return s.From }
+func (s Span) End() Pos { return s.To }
+
+// Text is a fragment of a line, such as the name of a header. A Text with an empty
+// Value and Span is absent from the source.
+type Text struct {
+	Span
+	Value string
+}
+
+// Lines is embedded in the nodes spanning whole lines.
+type Lines struct {
+	Span
+	// Raw is the exact source of the lines, including line endings.
+	Raw string
+}
+
+func (l Lines) source() string { return l.Raw }
+
+// Source returns the exact source of a node, including the line endings of nodes
+// spanning whole lines.
+func Source(node Node) string {
+	switch node := node.(type) {
+	case interface{ source() string }:
+		return node.source()
+	case Text:
+		return node.Value
+	case *Text:
+		return node.Value
+	}
+	return ""
+}
+
+// Separator is a `### <name>` line starting a new request.
+type Separator struct {
+	Lines
+	Name Text
+}
+
+// Blank is an empty or whitespace only line.
+type Blank struct {
+	Lines
+}
+
+// Comment is a `#` or `//` comment line.
+type Comment struct {
+	Lines
+	// Marker is either `#` or `//`.
+	Marker string
+	// Text is the text following the marker.
+	Text Text
+}
+
+// Annotation is a comment of the form `# @key value` before the request line.
+type Annotation struct {
+	Lines
+	// Marker is either `#` or `//`.
+	Marker string
+	Key    Text
+	Value  Text
+}
+
+// Variable is a file variable declaration of the form `@name = value`.
+type Variable struct {
+	Lines
+	Name  Text
+	Value Text
+}
+
+// RequestLine is the `<method> <url> [HTTP/x.y]` line of a request. Method is absent
+// when the short form `<url>` is used.
+type RequestLine struct {
+	Lines
+	Method Text
+	URL    Text
+	Proto  Text
+}
+
+// URLContinuation is an indented `?query` or `&query` line continuing the URL.
+type URLContinuation struct {
+	Lines
+	Text Text
+}
+
+// Header is a `<key>: <value>` line.
+type Header struct {
+	Lines
+	Key   Text
+	Value Text
+}
+
+// Body is an inline request body, it spans from its first to its last non-blank line.
+type Body struct {
+	Lines
+	// Value is the text of the body with a line feed after each line.
+	Value string
+}
+
+// BodyFile is a `< path` or `<@ path` line loading the body from a file.
+type BodyFile struct {
+	Lines
+	Path Text
+	// Substitute is set for `<@ path`, the variables of the file content are replaced.
+	Substitute bool
+}
+
+// Script is a pre or post-request script, either inline `< {% code %}`, possibly
+// spanning multiple lines, or loaded from a file with `< path.js`.
+type Script struct {
+	Lines
+	// Post is set when the script follows the request line.
+	Post bool
+	// Code is the source between `{%` and `%}` of an inline script.
+	Code Text
+	// Path is the path of a script file.
+	Path Text
+}
+
+// ResponseRedirect is a `>> path` or `>>! path` line saving the response to a file.
+type ResponseRedirect struct {
+	Lines
+	Path Text
+	// Overwrite is set for `>>! path`.
+	Overwrite bool
+}
+
+// Bad holds lines that could not be parsed. Once a request contains a Bad node the
+// rest of the request, up to the next separator, is part of the node.
+type Bad struct {
+	Lines
+	// Reason describes why the first line of the node is invalid.
+	Reason string
+}
+
+// Request is a block of a file from a separator up to the next one. The first block
+// of a file has no separator when the file does not start with one, and blocks may
+// have no request line, ex., when they only declare variables.
+type Request struct {
+	Span
+	// Nodes are all the nodes of the block in source order, including the separator.
+	Nodes []Node
+
+	Separator  *Separator
+	Line       *RequestLine
+	Headers    []*Header
+	PreScript  *Script
+	PostScript *Script
+	Body       *Body
+	BodyFile   *BodyFile
+	Redirect   *ResponseRedirect
+	Bad        *Bad
+}
+
+func (r *Request) source() string {
+	var builder strings.Builder
+	for _, node := range r.Nodes {
+		builder.WriteString(Source(node))
+	}
+	return builder.String()
+}
+
+// File is the syntax tree of a .http file.
+type File struct {
+	Requests []*Request
+	// lines holds the offsets at which each line starts
+	lines []Pos
+}
+
+// Position returns the line and column of the offset.
+func (f *File) Position(pos Pos) Position {
+	return position(f.lines, 0, pos)
+}
+
+// position returns the position of the offset given the offsets at which the lines
+// start, first is the number of lines before them.
+func position(lines []Pos, first int, pos Pos) Position {
+	i := sort.Search(len(lines), func(i int) bool { return lines[i] > pos }) - 1
+	if i < 0 {
+		return Position{Offset: int(pos), Line: first + 1, Column: int(pos) + 1}
+	}
+	return Position{Offset: int(pos), Line: first + i + 1, Column: int(pos-lines[i]) + 1}
+}
+
+// Inspect calls fn for each request of the file and each node of the requests in
+// source order.
+func Inspect(f *File, fn func(Node)) {
+	for _, req := range f.Requests {
+		fn(req)
+		for _, node := range req.Nodes {
+			fn(node)
+		}
+	}
+}
@@ -0,0 +1,539 @@
+package ast
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// SeparatorPrefix starts the lines separating requests.
+const SeparatorPrefix = "###"
+
+var (
+	headerRegexp        = regexp.MustCompile(`^([^:]*[^:\s])\s*:\s*(.*)`)
+	scriptStartRegexp   = regexp.MustCompile(`^<\s*\{%`)
+	scriptFileRegexp    = regexp.MustCompile(`^<\s*(.*\.js)$`)
+	scriptEndRegexp     = regexp.MustCompile(`%\}`)
+	scriptOneLineRegexp = regexp.MustCompile(`^<\s*\{%(.*)%\}`)
+
+	// requestLineRegexp matches `<method> <url> [HTTP/x.y]` where the method is any RFC 9110
+	// token and the url is the rest of the line, which may contain spaces, e.g. in
+	// templates such as {{$randomInt 1 10}} or in raw query strings.
+	requestLineRegexp = regexp.MustCompile("^([!#$%&'*+.^_`|~0-9A-Za-z-]+)\\s+(.+?)(?:\\s+(HTTP/\\d+(?:\\.\\d+)?))?$")
+	// shortRequestLineRegexp matches `<url> [HTTP/x.y]`, the method defaults to GET.
+	shortRequestLineRegexp = regexp.MustCompile(`^(.+?)(?:\s+(HTTP/\d+(?:\.\d+)?))?$`)
+	// templateRegexp matches the {{templates}} of a line.
+	templateRegexp = regexp.MustCompile(`\{\{.*?\}\}`)
+	// annotationRegexp matches comments such as `# @name Get User` or `// @no-redirect`.
+	annotationRegexp = regexp.MustCompile(`^(#|//)\s*@([\w-]+)(?:\s+(.*?))?\s*$`)
+	// commentRegexp matches `# comment` and `// comment` lines.
+	commentRegexp = regexp.MustCompile(`^(#|//)(.*)$`)
+	// variableRegexp matches file variable declarations such as `@host = http://localhost`.
+	variableRegexp = regexp.MustCompile(`^@([\w.-]+)\s*=\s*(.*?)\s*$`)
+	// bodyFileRegexp matches body includes such as `< ./payload.json` or `<@ ./payload.json`,
+	// the path is separated by whitespace so that XML bodies such as `<?xml ...?>` are not
+	// taken for includes.
+	bodyFileRegexp = regexp.MustCompile(`^<(@)?\s+(.+?)\s*$`)
+	// redirectRegexp matches response redirections such as `>> ./out/user.json` or `>>! ./out/user.json`.
+	redirectRegexp = regexp.MustCompile(`^>>(!)?\s*(.+?)\s*$`)
+)
+
+// Reasons given by Bad nodes.
+const (
+	ReasonInvalidRequestLine = "request does not include method or URL"
+	ReasonInvalidScript      = "invalid script"
+	ReasonUnterminatedScript = "unterminated script"
+	ReasonUnexpectedLine     = "unexpected line after the request body"
+)
+
+// ParseFile parses the source of a .http file. Invalid lines are reported as Bad
+// nodes rather than errors.
+func ParseFile(src []byte) *File {
+	p := NewParser(bytes.NewReader(src))
+	p.keepLines = true
+	f := &File{}
+	for {
+		req, err := p.Next()
+		if err != nil {
+			// reading from a bytes.Reader only fails with io.EOF
+			break
+		}
+		f.Requests = append(f.Requests, req)
+	}
+	f.lines = p.lines
+	return f
+}
+
+// Error is an error returned by the reader of a Parser, with the position at which
+// reading failed.
+type Error struct {
+	Position Position
+	Err      error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Position, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Parser parses the requests of a .http file read from an io.Reader one at a time,
+// lines of any length are supported.
+type Parser struct {
+	r *bufio.Reader
+	// offset is the offset of the next line to be read
+	offset Pos
+	// lines holds the offsets at which the lines of the current request and the lines
+	// read ahead start, first is the number of lines before them
+	lines []Pos
+	first int
+	// keepLines keeps the offsets of the lines of the previous requests as well
+	keepLines bool
+	// pending are the lines read ahead, in order, that are returned by the next calls
+	// to readLine
+	pending []*line
+	eof     bool
+}
+
+// NewParser returns a parser reading from r.
+func NewParser(r io.Reader) *Parser {
+	return &Parser{r: bufio.NewReader(r)}
+}
+
+// Position returns the line and column of an offset of the last request returned by
+// Next. The offsets of the lines of the previous requests are not kept, so that the
+// memory used does not grow with the input.
+func (p *Parser) Position(pos Pos) Position {
+	return position(p.lines, p.first, pos)
+}
+
+// Next parses the next request of the file. It returns io.EOF once all the requests
+// have been parsed, other errors are returned as an *Error when reading fails.
+func (p *Parser) Next() (*Request, error) {
+	if !p.keepLines {
+		// only the lines read ahead, which are the last lines read, belong to the next
+		// requests
+		drop := len(p.lines) - len(p.pending)
+		p.first += drop
+		p.lines = append(p.lines[:0], p.lines[drop:]...)
+	}
+	l, err := p.readLine()
+	if err != nil {
+		return nil, err
+	}
+	b := &blockParser{p: p, req: &Request{Span: Span{From: l.pos, To: l.pos}}}
+	if isSeparator(l.text) {
+		b.separator(l)
+	} else {
+		p.unread(l)
+	}
+	for {
+		l, err := p.readLine()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if isSeparator(l.text) {
+			p.unread(l)
+			break
+		}
+		if err := b.line(l); err != nil {
+			return nil, err
+		}
+	}
+	b.flushBody()
+	return b.req, nil
+}
+
+// line is a line of the file.
+type line struct {
+	// raw is the source of the line including its line ending.
+	raw string
+	// text is the line without its line ending.
+	text string
+	pos  Pos
+}
+
+func (p *Parser) readLine() (*line, error) {
+	if len(p.pending) > 0 {
+		l := p.pending[0]
+		p.pending = p.pending[1:]
+		return l, nil
+	}
+	if p.eof {
+		return nil, io.EOF
+	}
+	raw, err := p.r.ReadString('\n')
+	if errors.Is(err, io.EOF) {
+		p.eof = true
+		if raw == "" {
+			return nil, io.EOF
+		}
+	} else if err != nil {
+		return nil, &Error{
+			Position: Position{Offset: int(p.offset) + len(raw), Line: p.first + len(p.lines) + 1, Column: len(raw) + 1},
+			Err:      err,
+		}
+	}
+	l := &line{
+		raw:  raw,
+		text: strings.TrimSuffix(strings.TrimSuffix(raw, "\n"), "\r"),
+		pos:  p.offset,
+	}
+	p.lines = append(p.lines, p.offset)
+	p.offset += Pos(len(raw))
+	return l, nil
+}
+
+func (p *Parser) unread(l *line) {
+	p.pending = append([]*line{l}, p.pending...)
+}
+
+type state int
+
+const (
+	// statePreamble is the state before the request line
+	statePreamble state = iota
+	// stateRequestLine is the state after the request line, before the headers
+	stateRequestLine
+	stateHeaders
+	stateBody
+	// stateTrailer is the state after a body file, a post-request script or a redirect
+	stateTrailer
+	// stateBad is the state once a line could not be parsed
+	stateBad
+)
+
+// blockParser parses the lines of a single request.
+type blockParser struct {
+	p     *Parser
+	req   *Request
+	state state
+	// body holds the lines of the inline body being read and blanks the blank lines
+	// following them, which belong to the body only if more body lines follow.
+	body   []*line
+	blanks []*line
+}
+
+func (b *blockParser) add(node Node) {
+	b.req.Nodes = append(b.req.Nodes, node)
+	b.req.To = node.End()
+}
+
+func (b *blockParser) separator(l *line) {
+	rest := l.text[len(SeparatorPrefix):]
+	sep := &Separator{Lines: lines(l)}
+	if name := strings.TrimSpace(rest); name != "" {
+		sep.Name = text(l, len(SeparatorPrefix)+indent(rest), name)
+	}
+	b.req.Separator = sep
+	b.add(sep)
+}
+
+func (b *blockParser) line(l *line) error {
+	trimmed := strings.TrimSpace(l.text)
+	switch b.state {
+	case stateBad:
+		b.req.Bad.Raw += l.raw
+		b.req.Bad.To = l.pos + Pos(len(l.raw))
+		b.req.To = b.req.Bad.To
+		return nil
+	case statePreamble:
+		switch {
+		case trimmed == "":
+			b.add(&Blank{Lines: lines(l)})
+		case isComment(trimmed):
+			b.comment(l, true)
+		case variableRegexp.MatchString(trimmed):
+			m := match(l, variableRegexp)
+			b.add(&Variable{Lines: lines(l), Name: m.text(1), Value: m.text(2)})
+		case strings.HasPrefix(trimmed, "<"):
+			return b.script(l, false)
+		default:
+			b.requestLine(l)
+		}
+		return nil
+	case stateRequestLine:
+		if isURLContinuation(l.text) {
+			b.add(&URLContinuation{Lines: lines(l), Text: text(l, indent(l.text), trimmed)})
+			return nil
+		}
+		b.state = stateHeaders
+		fallthrough
+	case stateHeaders:
+		switch {
+		case trimmed == "":
+			b.add(&Blank{Lines: lines(l)})
+			b.state = stateBody
+		case isComment(trimmed):
+			b.comment(l, false)
+		case headerRegexp.MatchString(l.text):
+			m := match(l, headerRegexp)
+			header := &Header{Lines: lines(l), Key: m.text(1), Value: m.text(2)}
+			b.req.Headers = append(b.req.Headers, header)
+			b.add(header)
+		default:
+			b.state = stateBody
+			return b.line(l)
+		}
+		return nil
+	case stateBody:
+		switch {
+		case trimmed == "":
+			if len(b.body) == 0 {
+				b.add(&Blank{Lines: lines(l)})
+			} else {
+				b.blanks = append(b.blanks, l)
+			}
+		case scriptStartRegexp.MatchString(trimmed) || scriptFileRegexp.MatchString(trimmed):
+			b.flushBody()
+			return b.script(l, true)
+		case redirectRegexp.MatchString(trimmed) && (len(b.body) == 0 || b.endsBody()):
+			b.flushBody()
+			b.redirect(l)
+		case bodyFileRegexp.MatchString(trimmed) && len(b.body) == 0:
+			m := match(l, bodyFileRegexp)
+			file := &BodyFile{Lines: lines(l), Path: m.text(2), Substitute: m.text(1).Value == "@"}
+			b.req.BodyFile = file
+			b.add(file)
+			b.state = stateTrailer
+		default:
+			b.body = append(b.body, b.blanks...)
+			b.body = append(b.body, l)
+			b.blanks = nil
+		}
+		return nil
+	case stateTrailer:
+		switch {
+		case trimmed == "":
+			b.add(&Blank{Lines: lines(l)})
+		case isComment(trimmed):
+			b.comment(l, false)
+		case scriptStartRegexp.MatchString(trimmed) || scriptFileRegexp.MatchString(trimmed):
+			return b.script(l, true)
+		case redirectRegexp.MatchString(trimmed):
+			b.redirect(l)
+		default:
+			b.bad(l, ReasonUnexpectedLine)
+		}
+	}
+	return nil
+}
+
+// endsBody reports whether the lines following a `>>` line of the body belong to the
+// trailer of the request, the `>>` line is then a response redirect rather than a line
+// of the body. The lines are read ahead and unread.
+func (b *blockParser) endsBody() bool {
+	var read []*line
+	defer func() {
+		for i := len(read) - 1; i >= 0; i-- {
+			b.p.unread(read[i])
+		}
+	}()
+	for {
+		l, err := b.p.readLine()
+		if err != nil {
+			// the end of the file, or a read error that is left to the next read
+			return true
+		}
+		read = append(read, l)
+		trimmed := strings.TrimSpace(l.text)
+		switch {
+		case isSeparator(l.text):
+			return true
+		case trimmed == "" || isComment(trimmed):
+		case scriptStartRegexp.MatchString(trimmed) || scriptFileRegexp.MatchString(trimmed) || redirectRegexp.MatchString(trimmed):
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+func (b *blockParser) comment(l *line, annotations bool) {
+	if annotations && annotationRegexp.MatchString(strings.TrimSpace(l.text)) {
+		m := match(l, annotationRegexp)
+		b.add(&Annotation{Lines: lines(l), Marker: m.text(1).Value, Key: m.text(2), Value: m.text(3)})
+		return
+	}
+	m := match(l, commentRegexp)
+	b.add(&Comment{Lines: lines(l), Marker: m.text(1).Value, Text: m.text(2)})
+}
+
+func (b *blockParser) requestLine(l *line) {
+	node := &RequestLine{Lines: lines(l)}
+	// the target of a method that is not uppercase must be a URL without spaces, so that
+	// lines of prose such as `see below` or `send /users now` are not taken for request
+	// lines
+	if m := match(l, requestLineRegexp); m != nil && (m.text(1).Value == strings.ToUpper(m.text(1).Value) || isURL(m.text(2).Value) && !containsSpace(m.text(2).Value)) {
+		node.Method, node.URL, node.Proto = m.text(1), m.text(2), m.text(3)
+	} else if m := match(l, shortRequestLineRegexp); m != nil && isURL(m.text(1).Value) {
+		node.URL, node.Proto = m.text(1), m.text(2)
+	} else {
+		b.bad(l, ReasonInvalidRequestLine)
+		return
+	}
+	b.req.Line = node
+	b.add(node)
+	b.state = stateRequestLine
+}
+
+func (b *blockParser) redirect(l *line) {
+	m := match(l, redirectRegexp)
+	redirect := &ResponseRedirect{Lines: lines(l), Path: m.text(2), Overwrite: m.text(1).Value == "!"}
+	b.req.Redirect = redirect
+	b.add(redirect)
+	b.state = stateTrailer
+}
+
+// script parses the script starting on the line, multi-line scripts are read up to
+// the line closing them.
+func (b *blockParser) script(l *line, post bool) error {
+	script := &Script{Lines: lines(l), Post: post}
+	trimmed := strings.TrimSpace(l.text)
+	switch {
+	case scriptFileRegexp.MatchString(trimmed):
+		script.Path = match(l, scriptFileRegexp).text(1)
+	case scriptOneLineRegexp.MatchString(trimmed):
+		script.Code = match(l, scriptOneLineRegexp).text(1)
+	case scriptStartRegexp.MatchString(trimmed):
+		start := l.pos + Pos(scriptStartRegexp.FindStringIndex(l.text[indent(l.text):])[1]+indent(l.text))
+		for {
+			next, err := b.p.readLine()
+			if errors.Is(err, io.EOF) || (err == nil && isSeparator(next.text)) {
+				if err == nil {
+					b.p.unread(next)
+				}
+				b.state = stateBad
+				b.req.Bad = &Bad{Lines: script.Lines, Reason: ReasonUnterminatedScript}
+				b.add(b.req.Bad)
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			script.Raw += next.raw
+			script.To = next.pos + Pos(len(next.raw))
+			if loc := scriptEndRegexp.FindAllStringIndex(next.text, -1); loc != nil {
+				end := next.pos + Pos(loc[len(loc)-1][0])
+				code := script.Raw[start-script.From : end-script.From]
+				script.Code = Text{Span: Span{From: start, To: end}, Value: code}
+				break
+			}
+		}
+	default:
+		b.bad(l, ReasonInvalidScript)
+		return nil
+	}
+	if post {
+		b.req.PostScript = script
+		b.state = stateTrailer
+	} else {
+		b.req.PreScript = script
+	}
+	b.add(script)
+	return nil
+}
+
+func (b *blockParser) bad(l *line, reason string) {
+	b.flushBody()
+	b.req.Bad = &Bad{Lines: lines(l), Reason: reason}
+	b.add(b.req.Bad)
+	b.state = stateBad
+}
+
+// flushBody adds the body read so far and the blank lines following it to the request.
+func (b *blockParser) flushBody() {
+	if len(b.body) > 0 {
+		body := &Body{Lines: Lines{Span: Span{From: b.body[0].pos}}}
+		var value strings.Builder
+		for _, l := range b.body {
+			body.Raw += l.raw
+			value.WriteString(l.text + "\n")
+		}
+		last := b.body[len(b.body)-1]
+		body.To = last.pos + Pos(len(last.raw))
+		body.Value = value.String()
+		b.req.Body = body
+		b.add(body)
+	}
+	for _, l := range b.blanks {
+		b.add(&Blank{Lines: lines(l)})
+	}
+	b.body, b.blanks = nil, nil
+}
+
+func isSeparator(text string) bool {
+	return strings.HasPrefix(text, SeparatorPrefix)
+}
+
+func isComment(trimmed string) bool {
+	return strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "//")
+}
+
+// isURLContinuation reports whether the line is an indented `?query` or `&query`
+// line continuing the URL of the request line.
+func isURLContinuation(text string) bool {
+	trimmed := strings.TrimLeft(text, " \t")
+	return trimmed != text && (strings.HasPrefix(trimmed, "?") || strings.HasPrefix(trimmed, "&"))
+}
+
+// isURL reports whether target can stand on its own as a request line, i.e. it is
+// an absolute URL, an absolute path or starts with a template such as {{host}}.
+func isURL(target string) bool {
+	return strings.Contains(target, "://") ||
+		strings.HasPrefix(target, "/") ||
+		strings.HasPrefix(target, "{{")
+}
+
+// containsSpace reports whether target contains whitespace outside of {{templates}}.
+func containsSpace(target string) bool {
+	return strings.ContainsAny(templateRegexp.ReplaceAllString(target, ""), " \t")
+}
+
+func indent(text string) int {
+	return len(text) - len(strings.TrimLeft(text, " \t"))
+}
+
+func lines(l *line) Lines {
+	return Lines{Span: Span{From: l.pos, To: l.pos + Pos(len(l.raw))}, Raw: l.raw}
+}
+
+func text(l *line, offset int, value string) Text {
+	from := l.pos + Pos(offset)
+	return Text{Span: Span{From: from, To: from + Pos(len(value))}, Value: value}
+}
+
+// submatch holds the groups matched by a regexp in the trimmed text of a line.
+type submatch struct {
+	l   *line
+	loc []int
+	// offset is the offset of the trimmed text in the line
+	offset int
+}
+
+// match matches the regexp against the line without its surrounding whitespace.
+func match(l *line, re *regexp.Regexp) *submatch {
+	offset := indent(l.text)
+	loc := re.FindStringSubmatchIndex(strings.TrimSpace(l.text))
+	if loc == nil {
+		return nil
+	}
+	return &submatch{l: l, loc: loc, offset: offset}
+}
+
+func (m *submatch) text(group int) Text {
+	start, end := m.loc[2*group], m.loc[2*group+1]
+	if start < 0 {
+		return Text{}
+	}
+	return text(m.l, m.offset+start, m.l.text[m.offset+start:m.offset+end])
+}
@@ -0,0 +1,236 @@
+package ast
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const input = "# Users API\r\n" + `@host = http://localhost:8080
+
+### Get User
+# @name Get The User
+< {% setEnv('id', '1') %}
+GET {{host}}/users/{{id}} HTTP/1.1
+    ?fields=name
+Accept: application/json
+// Accept: text/plain
+
+< {%
+  assert(response.statusCode === 200, 'ok')
+%}
+>>! ./out/user.json
+
+### Create User
+POST {{host}}/users
+Content-Type: application/json
+
+{
+  "name": "Fred"
+
+}
+
+
+< create.js
+### Upload
+PUT {{host}}/avatar
+
+<@ ./avatar.png
+### Broken
+not a request
+Accept: application/json
+`
+
+func TestParseFile(t *testing.T) {
+	f := ParseFile([]byte(input))
+
+	t.Run("The tree is lossless", func(t *testing.T) {
+		var builder strings.Builder
+		var last Pos
+		for _, req := range f.Requests {
+			for _, node := range req.Nodes {
+				if node.Pos() != last {
+					t.Errorf("node %T starts at %d, expected %d", node, node.Pos(), last)
+				}
+				last = node.End()
+				builder.WriteString(Source(node))
+			}
+		}
+		if diff := cmp.Diff(input, builder.String()); diff != "" {
+			t.Errorf("source mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("The nodes are typed", func(t *testing.T) {
+		var kinds [][]string
+		for _, req := range f.Requests {
+			var nodes []string
+			for _, node := range req.Nodes {
+				nodes = append(nodes, typeName(node))
+			}
+			kinds = append(kinds, nodes)
+		}
+		if diff := cmp.Diff([][]string{
+			{"Comment", "Variable", "Blank"},
+			{"Separator", "Annotation", "Script", "RequestLine", "URLContinuation", "Header", "Comment", "Blank", "Script", "ResponseRedirect", "Blank"},
+			{"Separator", "RequestLine", "Header", "Blank", "Body", "Blank", "Blank", "Script"},
+			{"Separator", "RequestLine", "Blank", "BodyFile"},
+			{"Separator", "Bad"},
+		}, kinds); diff != "" {
+			t.Errorf("nodes mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Text fragments point into the source", func(t *testing.T) {
+		req := f.Requests[1]
+		for _, fragment := range []Text{
+			req.Separator.Name,
+			req.Line.Method,
+			req.Line.URL,
+			req.Line.Proto,
+			req.Headers[0].Key,
+			req.Headers[0].Value,
+			req.PreScript.Code,
+			req.PostScript.Code,
+			req.Redirect.Path,
+		} {
+			if got := input[fragment.From:fragment.To]; got != fragment.Value {
+				t.Errorf("expected %q at %d:%d, got %q", fragment.Value, fragment.From, fragment.To, got)
+			}
+		}
+		if req.Line.Method.Value != "GET" || req.Line.URL.Value != "{{host}}/users/{{id}}" || req.Line.Proto.Value != "HTTP/1.1" {
+			t.Errorf("unexpected request line %+v", req.Line)
+		}
+		if !req.PostScript.Post || req.PreScript.Post || !req.Redirect.Overwrite {
+			t.Errorf("unexpected script or redirect flags")
+		}
+		if body := f.Requests[2].Body.Value; body != "{\n  \"name\": \"Fred\"\n\n}\n" {
+			t.Errorf("unexpected body %q", body)
+		}
+		if file := f.Requests[3].BodyFile; file.Path.Value != "./avatar.png" || !file.Substitute {
+			t.Errorf("unexpected body file %+v", file)
+		}
+	})
+
+	t.Run("Positions are reported as lines and columns", func(t *testing.T) {
+		bad := f.Requests[4].Bad
+		if bad.Reason != ReasonInvalidRequestLine {
+			t.Errorf("unexpected reason %q", bad.Reason)
+		}
+		if diff := cmp.Diff(Position{Offset: int(bad.Pos()), Line: 33, Column: 1}, f.Position(bad.Pos())); diff != "" {
+			t.Errorf("position mismatch (-want +got):\n%s", diff)
+		}
+		url := f.Requests[1].Line.URL
+		if got := f.Position(url.Pos()).String(); got != "7:5" {
+			t.Errorf("expected the url at 7:5, got %s", got)
+		}
+	})
+}
+
+func TestParseFile_unterminatedScript(t *testing.T) {
+	f := ParseFile([]byte("GET /users\n\n< {%\nassert(true)\n### Next\nGET /next\n"))
+	if len(f.Requests) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(f.Requests))
+	}
+	if bad := f.Requests[0].Bad; bad == nil || bad.Reason != ReasonUnterminatedScript {
+		t.Errorf("expected an unterminated script, got %+v", bad)
+	}
+	if f.Requests[1].Line == nil || f.Requests[1].Line.URL.Value != "/next" {
+		t.Errorf("expected the next request to be parsed, got %+v", f.Requests[1])
+	}
+}
+
+func TestParser_lines(t *testing.T) {
+	var builder strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&builder, "### Request %d\nGET /users/%d\nAccept: */*\n\n", i, i)
+	}
+	p := NewParser(strings.NewReader(builder.String()))
+	for i := 0; ; i++ {
+		req, err := p.Next()
+		if err != nil {
+			break
+		}
+		if got := p.Position(req.Line.URL.Pos()); got.Line != 4*i+2 || got.Column != 5 {
+			t.Fatalf("unexpected position %s of request %d", got, i)
+		}
+		if len(p.lines) > 5 {
+			t.Fatalf("expected the lines of the previous requests to be dropped, got %d lines", len(p.lines))
+		}
+	}
+}
+
+func TestParseFile_prose(t *testing.T) {
+	for _, line := range []string{"foo bar", "hello world", "see below", "send /users now", "get users"} {
+		t.Run(line, func(t *testing.T) {
+			req := ParseFile([]byte(line + "\n")).Requests[0]
+			if req.Line != nil || req.Bad == nil || req.Bad.Reason != ReasonInvalidRequestLine {
+				t.Errorf("expected an invalid request line, got %+v", req.Line)
+			}
+		})
+	}
+	for _, line := range []string{"get /users", "post {{host}}/users", "GET users"} {
+		t.Run(line, func(t *testing.T) {
+			if req := ParseFile([]byte(line + "\n")).Requests[0]; req.Line == nil {
+				t.Errorf("expected a request line, got %+v", req.Bad)
+			}
+		})
+	}
+}
+
+func TestParseFile_xmlBody(t *testing.T) {
+	body := "<?xml version=\"1.0\"?>\n<root>\n  <@name>Fred</@name>\n</root>\n"
+	f := ParseFile([]byte("POST /users\nContent-Type: application/xml\n\n" + body))
+	req := f.Requests[0]
+	if req.BodyFile != nil || req.Body == nil || req.Body.Value != body {
+		t.Errorf("expected an inline XML body, got %+v", req.Body)
+	}
+	f = ParseFile([]byte("POST /users\n\n<root/>\n"))
+	if req := f.Requests[0]; req.BodyFile != nil || req.Body == nil || req.Body.Value != "<root/>\n" {
+		t.Errorf("expected an inline XML body, got %+v", req.Body)
+	}
+}
+
+func TestParseFile_redirectInBody(t *testing.T) {
+	body := "> quoted\n>> nested quote\nend\n"
+	f := ParseFile([]byte("POST /notes\nContent-Type: text/markdown\n\n" + body + "\n>> ./out/note.json\n\n< {% assert(true, 'ok') %}\n"))
+	req := f.Requests[0]
+	if req.Body == nil || req.Body.Value != body {
+		t.Errorf("expected the >> line to belong to the body, got %+v", req.Body)
+	}
+	if req.Redirect == nil || req.Redirect.Path.Value != "./out/note.json" || req.PostScript == nil {
+		t.Errorf("expected the redirect and the script after the body, got %+v %+v", req.Redirect, req.PostScript)
+	}
+}
+
+func TestParseFile_testdata(t *testing.T) {
+	files, err := filepath.Glob("../testdata/*.http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			src, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var builder strings.Builder
+			Inspect(ParseFile(src), func(node Node) {
+				if _, ok := node.(*Request); !ok {
+					builder.WriteString(Source(node))
+				}
+			})
+			if diff := cmp.Diff(string(src), builder.String()); diff != "" {
+				t.Errorf("source mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func typeName(node Node) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", node), "*ast.")
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/go-rq/rq"
+)
+
+// runFmt formats the .http files given as arguments, or the standard input, in the
+// manner of gofmt.
+func runFmt(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	list := flags.Bool("l", false, "list files whose formatting differs from rq fmt's")
+	write := flags.Bool("w", false, "write result to (source) file instead of stdout")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: rq fmt [-l] [-w] [path ...]\n\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "rq fmt: cannot use -w with standard input")
+			return 2
+		}
+		if err := formatFile("<standard input>", os.Stdin, os.Stdout, *list, false); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+	code := 0
+	for _, arg := range flags.Args() {
+		err := filepath.WalkDir(arg, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			// files given explicitly are formatted whatever their extension
+			if entry.IsDir() || (path != arg && filepath.Ext(path) != ".http") {
+				return nil
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if err := formatFile(path, f, os.Stdout, *list, *write); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				code = 1
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = 1
+		}
+	}
+	return code
+}
+
+// formatFile formats the content of in. The name of the file is listed to out when
+// list is set and its formatting differs, the file is rewritten when write is set,
+// otherwise the formatted content is written to out.
+func formatFile(name string, in io.Reader, out io.Writer, list, write bool) error {
+	src, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	res, err := rq.Format(src)
+	if err != nil {
+		var parseErrs rq.ParseErrors
+		var parseErr *rq.ParseError
+		switch {
+		case errors.As(err, &parseErrs):
+			for _, e := range parseErrs {
+				e.File = name
+			}
+			return parseErrs
+		case errors.As(err, &parseErr):
+			parseErr.File = name
+			return parseErr
+		}
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	changed := !bytes.Equal(src, res)
+	if list && changed {
+		fmt.Fprintln(out, name)
+	}
+	if write {
+		if changed {
+			info, err := os.Stat(name)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(name, res, info.Mode().Perm())
+		}
+		return nil
+	}
+	if !list {
+		_, err = out.Write(res)
+	}
+	return err
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/go-rq/rq/lsp"
+)
+
+// runLsp runs the language server over stdio.
+func runLsp(args []string) int {
+	flags := flag.NewFlagSet("lsp", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: rq lsp\n\nrq lsp runs a language server for .http files over stdio.\n")
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if err := lsp.Serve(context.Background(), os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,52 @@
+// Command rq works with .http files.
+//
+// Usage:
+//
+//	rq <command> [arguments]
+//
+// The commands are:
+//
+//	fmt     format .http files
+//	vet     report likely mistakes in .http files
+//	lsp     run the language server over stdio
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// command is a subcommand of rq, it returns the exit code of the program.
+type command struct {
+	name  string
+	short string
+	run   func(args []string) int
+}
+
+var commands = []command{
+	{name: "fmt", short: "format .http files", run: runFmt},
+	{name: "vet", short: "report likely mistakes in .http files", run: runVet},
+	{name: "lsp", short: "run the language server over stdio", run: runLsp},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	for _, cmd := range commands {
+		if cmd.name == os.Args[1] {
+			os.Exit(cmd.run(os.Args[2:]))
+		}
+	}
+	fmt.Fprintf(os.Stderr, "rq: unknown command %q\n", os.Args[1])
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage:\n\n\trq <command> [arguments]\n\nThe commands are:\n\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "\t%-7s %s\n", cmd.name, cmd.short)
+	}
+}
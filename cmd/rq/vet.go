@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-rq/rq"
+	"github.com/go-rq/rq/ast"
+)
+
+// variables is a repeatable `-var key=value` flag.
+type variables map[string]string
+
+func (v variables) String() string {
+	return fmt.Sprint(map[string]string(v))
+}
+
+func (v variables) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("expected key=value, got %q", value)
+	}
+	v[key] = val
+	return nil
+}
+
+// runVet reports the parse errors and lint diagnostics of the .http files given as
+// arguments, the current directory by default.
+func runVet(args []string) int {
+	flags := flag.NewFlagSet("vet", flag.ContinueOnError)
+	env := variables{}
+	flags.Var(env, "var", "define an environment `key=value`, may be repeated")
+	disable := flags.String("disable", "", "comma separated `rules` to disable")
+	list := flags.Bool("rules", false, "list the rules and exit")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: rq vet [-var key=value] [-disable rules] [path ...]\n\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *list {
+		for _, rule := range rq.DefaultLintRules() {
+			fmt.Printf("%-24s %s\n", rule.Name, rule.Doc)
+		}
+		return 0
+	}
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	var options []rq.LintOption
+	if *disable != "" {
+		options = append(options, rq.WithoutLintRules(strings.Split(*disable, ",")...))
+	}
+	code := 0
+	for _, arg := range paths {
+		err := filepath.WalkDir(arg, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() || (path != arg && filepath.Ext(path) != ".http") {
+				return nil
+			}
+			if !vetFile(path, env, options) {
+				code = 1
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = 1
+		}
+	}
+	return code
+}
+
+// vetFile reports the parse errors and lint diagnostics of a file, the file is linted
+// on its own so that the variables set by the scripts of a file are not considered
+// defined in another. It returns false when any issue was found.
+func vetFile(path string, env map[string]string, options []rq.LintOption) bool {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false
+	}
+	ok := true
+	requests, err := rq.ParseRequests(string(src), rq.WithFileName(path))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		ok = false
+	}
+	options = append(options[:len(options):len(options)], rq.WithLintSource(ast.ParseFile(src)))
+	for _, diagnostic := range rq.Lint(requests, env, options...) {
+		fmt.Fprintln(os.Stderr, diagnostic)
+		ok = false
+	}
+	return ok
+}
@@ -0,0 +1,62 @@
+package rq
+
+import (
+	"errors"
+	"io"
+
+	"github.com/go-rq/rq/ast"
+)
+
+// Decoder parses the requests of a .http file read from an io.Reader one at a time,
+// so that large collections are not loaded in memory at once. Lines of any length
+// are supported.
+type Decoder struct {
+	p      *parser
+	blocks *ast.Parser
+	err    error
+}
+
+// NewDecoder returns a decoder reading requests from r. Referenced files are resolved
+// relative to the working directory unless WithFileName or WithFS is given.
+func NewDecoder(r io.Reader, options ...ParseOption) *Decoder {
+	p := &parser{}
+	for _, option := range options {
+		option(p)
+	}
+	blocks := ast.NewParser(r)
+	p.position = blocks.Position
+	return &Decoder{p: p, blocks: blocks}
+}
+
+// Next returns the next request. It returns io.EOF once all the requests have been
+// read. An invalid request is reported as a *ParseError, decoding can then continue
+// with the next request. Errors reading from the reader are reported as a *ParseError
+// with the position at which reading failed and are returned by all subsequent calls.
+func (d *Decoder) Next() (Request, error) {
+	if d.err != nil {
+		return Request{}, d.err
+	}
+	for {
+		block, err := d.blocks.Next()
+		if errors.Is(err, io.EOF) {
+			d.err = io.EOF
+			return Request{}, d.err
+		}
+		if err != nil {
+			d.err = err
+			var readErr *ast.Error
+			if errors.As(err, &readErr) {
+				d.err = &ParseError{File: d.p.file, Line: readErr.Position.Line, Column: readErr.Position.Column, Err: readErr.Err}
+			}
+			return Request{}, d.err
+		}
+		req, ok := d.p.request(block)
+		if errs := d.p.errs; len(errs) > 0 {
+			d.p.errs = nil
+			return Request{}, errs[0]
+		}
+		if ok {
+			return req, nil
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package rq
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestDecoder(t *testing.T) {
+	t.Run("Requests are decoded one at a time", func(t *testing.T) {
+		decoder := NewDecoder(strings.NewReader("### A\nGET /a\n\n### B\nnot a request\n\n### C\nGET /c\n"))
+		req, err := decoder.Next()
+		if err != nil || req.Name != "A" {
+			t.Fatalf("expected request A, got %+v, %v", req, err)
+		}
+		_, err = decoder.Next()
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) || !errors.Is(err, ErrInvalidRequest) || parseErr.Line != 5 {
+			t.Fatalf("expected an invalid request on line 5, got %v", err)
+		}
+		req, err = decoder.Next()
+		if err != nil || req.Name != "C" || req.Line != 8 {
+			t.Fatalf("expected request C on line 8, got %+v, %v", req, err)
+		}
+		if _, err := decoder.Next(); !errors.Is(err, io.EOF) {
+			t.Fatalf("expected io.EOF, got %v", err)
+		}
+	})
+
+	t.Run("Lines of any length are supported", func(t *testing.T) {
+		body := strings.Repeat("QUJD", 1<<18)
+		decoder := NewDecoder(strings.NewReader("POST /upload\nContent-Type: text/plain\n\n" + body + "\n\n###\nGET /next\n"))
+		req, err := decoder.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.Body != body+"\n" {
+			t.Errorf("expected a body of %d bytes, got %d", len(body)+1, len(req.Body))
+		}
+		if req, err := decoder.Next(); err != nil || req.URL != "/next" {
+			t.Errorf("expected the next request, got %+v, %v", req, err)
+		}
+	})
+
+	t.Run("Read errors are reported with their position", func(t *testing.T) {
+		failure := errors.New("connection reset")
+		r := io.MultiReader(strings.NewReader("GET /a\n\n###\nGET /b\nAcc"), iotest.ErrReader(failure))
+		decoder := NewDecoder(r, WithFileName("api.http"))
+		if _, err := decoder.Next(); err != nil {
+			t.Fatal(err)
+		}
+		_, err := decoder.Next()
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) || !errors.Is(err, failure) {
+			t.Fatalf("expected a read error, got %v", err)
+		}
+		if diff := cmp.Diff(ParseError{File: "api.http", Line: 5, Column: 4, Err: failure}, *parseErr, cmpopts.EquateErrors()); diff != "" {
+			t.Errorf("error mismatch (-want +got):\n%s", diff)
+		}
+		if got := err.Error(); got != "api.http:5:4: connection reset" {
+			t.Errorf("unexpected message %q", got)
+		}
+		if _, again := decoder.Next(); again != err {
+			t.Errorf("expected the error to be returned again, got %v", again)
+		}
+	})
+}
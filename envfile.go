@@ -0,0 +1,141 @@
+package rq
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Environment files define named environments, ex., `{"dev": {"host": "http://localhost"}}`,
+// the private file holds secrets, is usually not committed and overrides the values of
+// the public one.
+const (
+	EnvironmentFile        = "http-client.env.json"
+	PrivateEnvironmentFile = "http-client.private.env.json"
+	// SharedEnvironment is the name of the environment whose variables are available
+	// in all the environments.
+	SharedEnvironment = "$shared"
+)
+
+// Environments are the named environments of environment files.
+type Environments struct {
+	variables map[string]map[string]string
+	// secrets holds the keys of the secret variables of each environment.
+	secrets map[string]map[string]bool
+}
+
+// Get returns the variables of the environment name, including the shared variables.
+// The returned map can be modified.
+func (e Environments) Get(name string) (map[string]string, bool) {
+	env, ok := e.variables[name]
+	if !ok || name == SharedEnvironment {
+		return nil, false
+	}
+	variables := maps.Clone(e.variables[SharedEnvironment])
+	if variables == nil {
+		variables = map[string]string{}
+	}
+	maps.Copy(variables, env)
+	return variables, true
+}
+
+// SecretKeys returns the sorted keys of the secret variables of the environment name,
+// including the shared variables: the variables of the private file and the values
+// with the SecretPrefix.
+func (e Environments) SecretKeys(name string) []string {
+	variables, ok := e.Get(name)
+	if !ok {
+		return nil
+	}
+	var secrets []string
+	for key := range variables {
+		// the variables of the environment override the shared ones
+		source := SharedEnvironment
+		if _, ok := e.variables[name][key]; ok {
+			source = name
+		}
+		if e.secrets[source][key] {
+			secrets = append(secrets, key)
+		}
+	}
+	sort.Strings(secrets)
+	return secrets
+}
+
+// Names returns the sorted names of the environments.
+func (e Environments) Names() []string {
+	names := make([]string, 0, len(e.variables))
+	for name := range e.variables {
+		if name != SharedEnvironment {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadEnvironments reads the environments of the http-client.env.json and
+// http-client.private.env.json files of dir, the variables of the private file
+// override the ones of the public file and are secret, as are the values with the
+// SecretPrefix, which is removed, see Environments.SecretKeys. Missing files are
+// ignored. Values that are not strings, such as numbers, are kept as JSON.
+func LoadEnvironments(dir string) (Environments, error) {
+	return loadEnvironments(func(name string) (string, []byte, error) {
+		name = filepath.Join(dir, name)
+		b, err := os.ReadFile(name)
+		return name, b, err
+	})
+}
+
+// LoadEnvironmentsFS reads the environment files of the directory dir of fsys, see
+// LoadEnvironments.
+func LoadEnvironmentsFS(fsys fs.FS, dir string) (Environments, error) {
+	return loadEnvironments(func(name string) (string, []byte, error) {
+		name = path.Join(dir, name)
+		b, err := fs.ReadFile(fsys, name)
+		return name, b, err
+	})
+}
+
+func loadEnvironments(read func(name string) (string, []byte, error)) (Environments, error) {
+	environments := Environments{variables: map[string]map[string]string{}, secrets: map[string]map[string]bool{}}
+	for _, name := range []string{EnvironmentFile, PrivateEnvironmentFile} {
+		file, b, err := read(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return Environments{}, err
+		}
+		var values map[string]map[string]json.RawMessage
+		if err := json.Unmarshal(b, &values); err != nil {
+			return Environments{}, fmt.Errorf("%s: %w", file, err)
+		}
+		for env, variables := range values {
+			if environments.variables[env] == nil {
+				environments.variables[env] = map[string]string{}
+				environments.secrets[env] = map[string]bool{}
+			}
+			for key, raw := range variables {
+				var value string
+				if err := json.Unmarshal(raw, &value); err != nil {
+					var buffer bytes.Buffer
+					json.Compact(&buffer, raw)
+					value = buffer.String()
+				}
+				secret := name == PrivateEnvironmentFile || strings.HasPrefix(value, SecretPrefix)
+				environments.variables[env][key] = strings.TrimPrefix(value, SecretPrefix)
+				environments.secrets[env][key] = secret
+			}
+		}
+	}
+	return environments, nil
+}
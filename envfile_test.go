@@ -0,0 +1,85 @@
+package rq
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLoadEnvironments(t *testing.T) {
+	files := map[string]string{
+		EnvironmentFile: `{
+  "$shared": {"version": "v1"},
+  "dev": {"host": "http://localhost", "port": 8080, "debug": true},
+  "staging": {"host": "https://staging.example.com", "version": "v2"}
+}`,
+		PrivateEnvironmentFile: `{"dev": {"token": "dev-secret"}, "staging": {"token": "staging-secret", "host": "https://private.example.com"}}`,
+	}
+	dir := t.TempDir()
+	fsys := fstest.MapFS{}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		fsys["api/"+name] = &fstest.MapFile{Data: []byte(content)}
+	}
+
+	t.Run("The private file overrides the public one with secret values", func(t *testing.T) {
+		for name, load := range map[string]func() (Environments, error){
+			"LoadEnvironments":   func() (Environments, error) { return LoadEnvironments(dir) },
+			"LoadEnvironmentsFS": func() (Environments, error) { return LoadEnvironmentsFS(fsys, "api") },
+		} {
+			environments, err := load()
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if diff := cmp.Diff([]string{"dev", "staging"}, environments.Names()); diff != "" {
+				t.Errorf("%s: names mismatch (-want +got):\n%s", name, diff)
+			}
+			dev, ok := environments.Get("dev")
+			if diff := cmp.Diff(map[string]string{
+				"host":    "http://localhost",
+				"port":    "8080",
+				"debug":   "true",
+				"token":   "dev-secret",
+				"version": "v1",
+			}, dev); !ok || diff != "" {
+				t.Errorf("%s: dev mismatch (-want +got):\n%s", name, diff)
+			}
+			staging, _ := environments.Get("staging")
+			if diff := cmp.Diff(map[string]string{
+				"host":    "https://private.example.com",
+				"token":   "staging-secret",
+				"version": "v2",
+			}, staging); diff != "" {
+				t.Errorf("%s: staging mismatch (-want +got):\n%s", name, diff)
+			}
+			if diff := cmp.Diff([]string{"token"}, environments.SecretKeys("dev")); diff != "" {
+				t.Errorf("%s: dev secrets mismatch (-want +got):\n%s", name, diff)
+			}
+			if diff := cmp.Diff([]string{"host", "token"}, environments.SecretKeys("staging")); diff != "" {
+				t.Errorf("%s: staging secrets mismatch (-want +got):\n%s", name, diff)
+			}
+			if _, ok := environments.Get("prod"); ok {
+				t.Errorf("%s: expected prod to be undefined", name)
+			}
+		}
+	})
+
+	t.Run("Missing files are ignored", func(t *testing.T) {
+		environments, err := LoadEnvironments(t.TempDir())
+		if err != nil || len(environments.Names()) != 0 {
+			t.Errorf("expected no environments, got %v, %v", environments, err)
+		}
+	})
+
+	t.Run("Invalid files are reported", func(t *testing.T) {
+		_, err := LoadEnvironmentsFS(fstest.MapFS{EnvironmentFile: {Data: []byte(`{"dev": [1]}`)}}, ".")
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
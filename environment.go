@@ -2,17 +2,34 @@ package rq
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
 	"regexp"
+	"strings"
+	"sync"
+	"time"
 )
 
 var variableRegexp = regexp.MustCompile(`{{(.*?)}}`)
 
 type environmentContextKey struct{}
 
-func replaceVariables(input string, variables map[string]string) string {
+// errUndefined is returned by lookups for variables that are not defined.
+var errUndefined = errors.New("undefined")
+
+// lookupFunc returns the value of the expression of a {{template}}, ex., `host` or
+// `$processEnv HOME`. It returns errUndefined for undefined variables.
+type lookupFunc func(expr string) (string, error)
+
+// replaceVariables replaces the {{templates}} of input, the templates that cannot be
+// resolved are kept as is.
+func replaceVariables(input string, lookup lookupFunc) string {
 	result := variableRegexp.ReplaceAllStringFunc(input, func(match string) string {
-		varName := match[2 : len(match)-2]
-		if val, ok := variables[varName]; ok {
+		if val, err := lookup(strings.TrimSpace(match[2 : len(match)-2])); err == nil {
 			return val
 		}
 		return match
@@ -20,17 +37,289 @@ func replaceVariables(input string, variables map[string]string) string {
 	return result
 }
 
+// variables returns the variables available to the templates of the request. The environment
+// takes precedence over the file variables, whose values are resolved against both and the
+// fallback.
+func (r Request) variables(env map[string]string, fallback lookupFunc) map[string]string {
+	if len(r.Variables) == 0 {
+		return env
+	}
+	variables := maps.Clone(r.Variables)
+	maps.Copy(variables, env)
+	lookup := withFilters(func(name string) (string, error) {
+		if value, ok := variables[name]; ok {
+			return value, nil
+		}
+		return fallback(name)
+	})
+	// file variables may reference each other in any order, resolve them until
+	// no more substitutions can be made
+	for i := 0; i < len(r.Variables); i++ {
+		changed := false
+		for key := range r.Variables {
+			if _, ok := env[key]; ok {
+				continue
+			}
+			if resolved := replaceVariables(variables[key], lookup); resolved != variables[key] {
+				variables[key] = resolved
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+	return variables
+}
+
+// lookup returns the function resolving the templates of the request, looking up
+// variables in order in:
+//   - the environment of the context
+//   - the state store of the context
+//   - the file variables of the request
+//   - the sources set with WithEnvironmentSources
+//
+// Templates starting with `$` call template functions, see RegisterTemplateFunc, the
+// ones such as `login.response.body` reference the responses of the History, and
+// the values are transformed by the filters following them, see RegisterFilter.
+func (r Request) lookup(ctx context.Context) lookupFunc {
+	sources := getEnvironmentSources(ctx)
+	history := GetHistory(ctx)
+	tc := newTemplateContext(ctx, r.File)
+	fallback := func(expr string) (string, error) {
+		if strings.HasPrefix(expr, "$") {
+			value, ok, err := callTemplateFunc(tc, expr)
+			if !ok {
+				return "", errUndefined
+			}
+			return value, err
+		}
+		if name, path, ok := strings.Cut(expr, ".response."); ok {
+			return history.resolve(name, path)
+		}
+		for _, source := range sources {
+			if value, ok := source.Lookup(expr); ok {
+				return value, nil
+			}
+		}
+		return "", errUndefined
+	}
+	variables := r.variables(contextVariables(ctx), fallback)
+	return withFilters(func(expr string) (string, error) {
+		if value, ok := variables[expr]; ok {
+			return value, nil
+		}
+		return fallback(expr)
+	})
+}
+
+// dotEnvFiles caches the .env files by path, a file is read again once it is modified.
+var dotEnvFiles = struct {
+	sync.Mutex
+	entries map[string]dotEnvFile
+}{entries: map[string]dotEnvFile{}}
+
+type dotEnvFile struct {
+	modTime time.Time
+	size    int64
+	source  MapSource
+	// err is the error loading the file
+	err error
+}
+
+// dotEnv loads the .env file of the directory of file, or of the working directory
+// when file is empty. A missing file defines no variables, the error loading an
+// invalid file is returned.
+func dotEnv(file string) (MapSource, error) {
+	dir := "."
+	if file != "" {
+		dir = filepath.Dir(file)
+	}
+	path := filepath.Join(dir, DotEnvFile)
+	info, err := os.Stat(path)
+	if err != nil {
+		return MapSource{}, nil
+	}
+	dotEnvFiles.Lock()
+	defer dotEnvFiles.Unlock()
+	if cached, ok := dotEnvFiles.entries[path]; ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.source, cached.err
+	}
+	source, err := LoadDotEnv(path)
+	dotEnvFiles.entries[path] = dotEnvFile{modTime: info.ModTime(), size: info.Size(), source: source, err: err}
+	return source, err
+}
+
+// Environment holds the variables shared by the requests and scripts of a run. Values
+// are typed, scripts get back the numbers, booleans and objects they set, and templates
+// use their string form, see Lookup. An Environment is safe for concurrent use.
+//
+// Scopes layer environments, ex., a scope for the requests of a file on top of the
+// global environment: a scope reads the variables of its parents and its own variables
+// take precedence over theirs.
+type Environment struct {
+	mu     sync.RWMutex
+	parent *Environment
+	values map[string]any
+	// secrets holds the keys of the variables of the scope with secret values.
+	secrets map[string]bool
+}
+
+// NewEnvironment returns an environment holding a copy of values, the values with the
+// SecretPrefix are secret and the prefix is removed from them.
+func NewEnvironment(values map[string]string) *Environment {
+	env := &Environment{values: make(map[string]any, len(values)), secrets: map[string]bool{}}
+	for key, value := range values {
+		if strings.HasPrefix(value, SecretPrefix) {
+			env.SetSecret(key, strings.TrimPrefix(value, SecretPrefix))
+		} else {
+			env.Set(key, value)
+		}
+	}
+	return env
+}
+
+// Scope returns a new scope of the environment, the variables set in the scope are not
+// visible to the environment.
+func (e *Environment) Scope() *Environment {
+	return &Environment{parent: e, values: map[string]any{}, secrets: map[string]bool{}}
+}
+
+// Get returns the value of the variable key of the scope or of its parents.
+func (e *Environment) Get(key string) (any, bool) {
+	for env := e; env != nil; env = env.parent {
+		env.mu.RLock()
+		value, ok := env.values[key]
+		env.mu.RUnlock()
+		if ok {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// Lookup returns the string form of the variable key: strings are returned as is, null
+// values are empty and other values are written in JSON.
+func (e *Environment) Lookup(key string) (string, bool) {
+	value, ok := e.Get(key)
+	if !ok {
+		return "", false
+	}
+	return formatValue(value), true
+}
+
+// Set sets the variable key of the scope. The variables of a scope that were secret
+// stay secret when their value changes.
+func (e *Environment) Set(key string, value any) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.values[key] = value
+}
+
+// SetSecret sets the variable key of the scope to a secret value, see Redact.
+func (e *Environment) SetSecret(key string, value any) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.values[key] = value
+	e.secrets[key] = true
+}
+
+// Delete removes the variable key from the scope, the value of a parent scope is then
+// visible again.
+func (e *Environment) Delete(key string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.values, key)
+	delete(e.secrets, key)
+}
+
+// Reset removes all the variables of the scope.
+func (e *Environment) Reset() {
+	e.Restore(nil)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.secrets = map[string]bool{}
+}
+
+// Snapshot returns a copy of the variables of the scope, they are set back with Restore.
+func (e *Environment) Snapshot() map[string]any {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return maps.Clone(e.values)
+}
+
+// Restore replaces the variables of the scope with the ones of a Snapshot.
+func (e *Environment) Restore(snapshot map[string]any) {
+	values := maps.Clone(snapshot)
+	if values == nil {
+		values = map[string]any{}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.values = values
+}
+
+// Values returns the variables of the scope and of its parents.
+func (e *Environment) Values() map[string]any {
+	var values map[string]any
+	if e.parent != nil {
+		values = e.parent.Values()
+	} else {
+		values = map[string]any{}
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	maps.Copy(values, e.values)
+	return values
+}
+
+// Strings returns the string form of the variables of the scope and of its parents,
+// see Lookup.
+func (e *Environment) Strings() map[string]string {
+	values := e.Values()
+	result := make(map[string]string, len(values))
+	for key, value := range values {
+		result[key] = formatValue(value)
+	}
+	return result
+}
+
+func formatValue(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(encoded)
+}
+
+// WithEnvironment runs the requests with a new environment holding a copy of env, the
+// variables set by scripts are then read with GetEnvironment.
 func WithEnvironment(ctx context.Context, env map[string]string) context.Context {
+	return WithEnvironmentScope(ctx, NewEnvironment(env))
+}
+
+// WithEnvironmentScope runs the requests with the environment env, the variables set
+// by scripts are set in env.
+func WithEnvironmentScope(ctx context.Context, env *Environment) context.Context {
 	return context.WithValue(ctx, environmentContextKey{}, env)
 }
 
+// ResetEnvironment removes the variables of the environment of the context.
 func ResetEnvironment(ctx context.Context) {
-	WithEnvironment(ctx, map[string]string{})
+	GetEnvironment(ctx).Reset()
 }
 
-func GetEnvironment(ctx context.Context) map[string]string {
-	if env, ok := ctx.Value(environmentContextKey{}).(map[string]string); ok {
+// GetEnvironment returns the environment of the context, or a new empty environment
+// when the context has none.
+func GetEnvironment(ctx context.Context) *Environment {
+	if env, ok := ctx.Value(environmentContextKey{}).(*Environment); ok {
 		return env
 	}
-	return map[string]string{}
+	return NewEnvironment(nil)
 }
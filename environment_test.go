@@ -0,0 +1,125 @@
+package rq
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEnvironment(t *testing.T) {
+	t.Run("Variables are set and deleted", func(t *testing.T) {
+		env := NewEnvironment(map[string]string{"host": "localhost"})
+		env.Set("port", 8080)
+		env.Set("user", map[string]any{"id": 1, "admin": true})
+		env.Set("token", nil)
+		env.Delete("host")
+		if diff := cmp.Diff(map[string]string{
+			"port":  "8080",
+			"user":  `{"admin":true,"id":1}`,
+			"token": "",
+		}, env.Strings()); diff != "" {
+			t.Errorf("variables mismatch (-want +got):\n%s", diff)
+		}
+		if value, ok := env.Get("port"); !ok || value != 8080 {
+			t.Errorf("expected the typed value 8080, got %v", value)
+		}
+	})
+
+	t.Run("Scopes read the variables of their parents", func(t *testing.T) {
+		global := NewEnvironment(map[string]string{"host": "localhost", "user": "fred"})
+		file := global.Scope()
+		file.Set("user", "wilma")
+		request := file.Scope()
+		request.Set("id", "1")
+		if diff := cmp.Diff(map[string]string{"host": "localhost", "user": "wilma", "id": "1"}, request.Strings()); diff != "" {
+			t.Errorf("variables mismatch (-want +got):\n%s", diff)
+		}
+		if diff := cmp.Diff(map[string]string{"host": "localhost", "user": "fred"}, global.Strings()); diff != "" {
+			t.Errorf("global variables mismatch (-want +got):\n%s", diff)
+		}
+		file.Delete("user")
+		if value, _ := request.Lookup("user"); value != "fred" {
+			t.Errorf("expected the value of the global scope, got %q", value)
+		}
+	})
+
+	t.Run("Snapshots are restored", func(t *testing.T) {
+		env := NewEnvironment(map[string]string{"host": "localhost"})
+		snapshot := env.Snapshot()
+		env.Set("token", "abc")
+		env.Set("host", "example.com")
+		env.Restore(snapshot)
+		if diff := cmp.Diff(map[string]string{"host": "localhost"}, env.Strings()); diff != "" {
+			t.Errorf("variables mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("The environment of the context is reset", func(t *testing.T) {
+		ctx := WithEnvironment(context.Background(), map[string]string{"host": "localhost"})
+		ResetEnvironment(ctx)
+		if diff := cmp.Diff(map[string]string{}, GetEnvironment(ctx).Strings()); diff != "" {
+			t.Errorf("variables mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Scripts get typed values", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+		defer srv.Close()
+		request := Request{
+			Method: "GET",
+			URL:    "{{host}}/users/{{user}}?page={{page}}",
+			PreRequestScript: `setEnv('page', 2);
+setEnv('user', {id: 1, admin: true});
+environment.deleted = 'x';
+delete environment.deleted;`,
+			PostRequestScript: `assert(getEnv('page') + 1 === 3, 'page is a number');
+assert(getEnv('user').admin === true, 'user is an object');
+assert(!('deleted' in environment), 'deleted is deleted');`,
+		}
+		ctx := WithEnvironment(context.Background(), map[string]string{"host": srv.URL})
+		resp, err := request.Do(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]Assertion{
+			{Message: "page is a number", Success: true},
+			{Message: "user is an object", Success: true},
+			{Message: "deleted is deleted", Success: true},
+		}, resp.PostRequestAssertions); diff != "" {
+			t.Errorf("assertions mismatch (-want +got):\n%s", diff)
+		}
+		if diff := cmp.Diff(`/users/{"admin":true,"id":1}?page=2`, resp.Request.URL.Path+"?"+resp.Request.URL.RawQuery); diff != "" {
+			t.Errorf("url mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Requests share the environment concurrently", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+		defer srv.Close()
+		ctx := WithEnvironment(context.Background(), map[string]string{"host": srv.URL})
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				request := Request{
+					Method:            "GET",
+					URL:               "{{host}}",
+					PostRequestScript: fmt.Sprintf("setEnv('request%d', %d)", i, i),
+				}
+				if _, err := request.Do(ctx); err != nil {
+					t.Error(err)
+				}
+			}(i)
+		}
+		wg.Wait()
+		if got := len(GetEnvironment(ctx).Values()); got != 11 {
+			t.Errorf("expected 11 variables, got %d", got)
+		}
+	})
+}
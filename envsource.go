@@ -0,0 +1,112 @@
+package rq
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DotEnvFile is the name of the file read by `{{$dotenv NAME}}` templates.
+const DotEnvFile = ".env"
+
+// EnvironmentSource is a source of variables consulted when a {{variable}} is neither
+// in the environment of the context nor a file variable, see WithEnvironmentSources.
+type EnvironmentSource interface {
+	// Lookup returns the value of the variable name, it is false when the source does
+	// not define the variable.
+	Lookup(name string) (string, bool)
+}
+
+// EnvironmentSourceFunc is an EnvironmentSource implemented by a function.
+type EnvironmentSourceFunc func(name string) (string, bool)
+
+func (f EnvironmentSourceFunc) Lookup(name string) (string, bool) {
+	return f(name)
+}
+
+// MapSource is an EnvironmentSource holding variables in a map.
+type MapSource map[string]string
+
+func (m MapSource) Lookup(name string) (string, bool) {
+	value, ok := m[name]
+	return value, ok
+}
+
+// ProcessEnv returns the source of the environment variables of the process.
+func ProcessEnv() EnvironmentSource {
+	return EnvironmentSourceFunc(os.LookupEnv)
+}
+
+type environmentSourcesContextKey struct{}
+
+// WithEnvironmentSources sets the sources consulted in order for the {{variables}} that
+// are neither in the environment of the context nor file variables. The full lookup
+// chain of a .env file followed by the environment variables of the process is:
+//
+//	dotenv, err := rq.LoadDotEnv(".env")
+//	...
+//	ctx = rq.WithEnvironmentSources(ctx, dotenv, rq.ProcessEnv())
+func WithEnvironmentSources(ctx context.Context, sources ...EnvironmentSource) context.Context {
+	return context.WithValue(ctx, environmentSourcesContextKey{}, sources)
+}
+
+func getEnvironmentSources(ctx context.Context) []EnvironmentSource {
+	sources, _ := ctx.Value(environmentSourcesContextKey{}).([]EnvironmentSource)
+	return sources
+}
+
+// LoadDotEnv reads the variables of a .env file. Lines are of the form `NAME=value`,
+// optionally prefixed with `export`, values may be quoted and lines starting with `#`
+// are comments.
+func LoadDotEnv(path string) (MapSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	variables := MapSource{}
+	reader := bufio.NewReader(f)
+	for n := 1; ; n++ {
+		line, err := reader.ReadString('\n')
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			name, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+			if !ok || strings.TrimSpace(name) == "" {
+				return nil, fmt.Errorf("%s:%d: expected NAME=value", path, n)
+			}
+			value, uerr := dotEnvValue(strings.TrimSpace(value))
+			if uerr != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, n, uerr)
+			}
+			variables[strings.TrimSpace(name)] = value
+		}
+		if errors.Is(err, io.EOF) {
+			return variables, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// dotEnvValue unquotes a value, escape sequences are interpreted in double-quoted
+// values and trailing comments are removed from unquoted values.
+func dotEnvValue(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		return strconv.Unquote(value)
+	case strings.HasPrefix(value, "'"):
+		if len(value) < 2 || !strings.HasSuffix(value, "'") {
+			return "", fmt.Errorf("unterminated quoted value %s", value)
+		}
+		return value[1 : len(value)-1], nil
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(value), nil
+}
@@ -0,0 +1,126 @@
+package rq
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLoadDotEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DotEnvFile)
+	err := os.WriteFile(path, []byte(`# credentials
+TOKEN=abc123
+export USER = fred # the user
+QUOTED="multi\nline"
+SINGLE='{{not a template}}'
+EMPTY=
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := LoadDotEnv(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(MapSource{
+		"TOKEN":  "abc123",
+		"USER":   "fred",
+		"QUOTED": "multi\nline",
+		"SINGLE": "{{not a template}}",
+		"EMPTY":  "",
+	}, source); diff != "" {
+		t.Errorf("variables mismatch (-want +got):\n%s", diff)
+	}
+
+	if err := os.WriteFile(path, []byte("TOKEN=abc\nnot a variable\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadDotEnv(path); err == nil || err.Error() != path+":2: expected NAME=value" {
+		t.Errorf("expected an error on line 2, got %v", err)
+	}
+	if _, err := LoadDotEnv(filepath.Dir(path)); err == nil {
+		t.Error("expected the read error of a directory")
+	}
+}
+
+func TestRequest_ApplyEnv_sources(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, DotEnvFile), []byte("API_KEY=from-dotenv\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("RQ_TEST_TOKEN", "from-process")
+
+	t.Run("Templates read the .env file and the process environment", func(t *testing.T) {
+		request := Request{
+			Method:    "GET",
+			URL:       "{{host}}/users?key={{$dotenv API_KEY}}&missing={{$dotenv MISSING}}",
+			Headers:   Headers{{Key: "Authorization", Value: "Bearer {{ $processEnv RQ_TEST_TOKEN }}"}},
+			Variables: map[string]string{"host": "{{$processEnv RQ_TEST_HOST}}"},
+			File:      filepath.Join(dir, "api.http"),
+		}
+		t.Setenv("RQ_TEST_HOST", "http://localhost")
+		applied := applyEnv(t, context.Background(), request)
+		if diff := cmp.Diff("http://localhost/users?key=from-dotenv&missing={{$dotenv MISSING}}", applied.URL); diff != "" {
+			t.Errorf("url mismatch (-want +got):\n%s", diff)
+		}
+		if diff := cmp.Diff("Bearer from-process", applied.Headers.Get("Authorization")); diff != "" {
+			t.Errorf("header mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("The .env file is read again once modified", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, DotEnvFile)
+		request := Request{Method: "GET", URL: "/{{$dotenv API_KEY}}", File: filepath.Join(dir, "api.http")}
+		for i, key := range []string{"first", "second"} {
+			if err := os.WriteFile(path, []byte("API_KEY="+key+"\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			modTime := time.Now().Add(time.Duration(i) * time.Minute)
+			if err := os.Chtimes(path, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff("/"+key, applyEnv(t, context.Background(), request).URL); diff != "" {
+				t.Errorf("url mismatch (-want +got):\n%s", diff)
+			}
+		}
+	})
+
+	t.Run("Errors of the .env file are reported", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, DotEnvFile)
+		if err := os.WriteFile(path, []byte("TOKEN=abc\nnot a variable\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		request := Request{Method: "GET", URL: "/{{$dotenv TOKEN}}", File: filepath.Join(dir, "api.http")}
+		_, err := request.ApplyEnv(WithStrictVariables(context.Background()))
+		if err == nil || !strings.Contains(err.Error(), path+":2: expected NAME=value") {
+			t.Errorf("expected the error of the .env file, got %v", err)
+		}
+	})
+
+	t.Run("Sources are consulted in order after the environment and file variables", func(t *testing.T) {
+		request := Request{
+			Method:    "GET",
+			URL:       "/{{a}}/{{b}}/{{c}}/{{d}}/{{RQ_TEST_TOKEN}}/{{e}}",
+			Variables: map[string]string{"b": "file"},
+		}
+		ctx := WithEnvironment(context.Background(), map[string]string{"a": "env"})
+		ctx = WithEnvironmentSources(ctx,
+			MapSource{"a": "first", "b": "first", "c": "first"},
+			MapSource{"c": "second", "d": "second"},
+			ProcessEnv(),
+		)
+		if diff := cmp.Diff("/env/file/first/second/from-process/{{e}}", applyEnv(t, ctx, request).URL); diff != "" {
+			t.Errorf("url mismatch (-want +got):\n%s", diff)
+		}
+		if value, ok := request.Variable(ctx, "d"); !ok || value != "second" {
+			t.Errorf("expected d to be second, got %q", value)
+		}
+	})
+}
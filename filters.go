@@ -0,0 +1,176 @@
+package rq
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FilterFunc transforms the value of a template in a `{{name | filter args...}}`
+// expression, args are the space separated arguments following the name of the filter,
+// with quoted arguments unquoted.
+type FilterFunc func(value string, args ...string) (string, error)
+
+// defaultFilter is the name of the filter giving the value of undefined variables.
+const defaultFilter = "default"
+
+var (
+	filtersMu sync.RWMutex
+	filters   = map[string]FilterFunc{
+		defaultFilter: defaultFunc,
+		"base64":      base64Func,
+		"urlencode":   urlEncodeFunc,
+		"json":        jsonFunc,
+	}
+)
+
+// RegisterFilter registers the function of the `{{name | filter}}` filters, replacing
+// any filter with the same name, including built-in ones.
+func RegisterFilter(name string, fn FilterFunc) {
+	filtersMu.Lock()
+	defer filtersMu.Unlock()
+	filters[name] = fn
+}
+
+func filter(name string) (FilterFunc, bool) {
+	filtersMu.RLock()
+	defer filtersMu.RUnlock()
+	fn, ok := filters[name]
+	return fn, ok
+}
+
+// filterCall is a filter of a template with its arguments.
+type filterCall struct {
+	name string
+	args []string
+}
+
+// parsePipeline splits the expression of a template into the expression of the value
+// and the filters applied to it, ex., `name | default "guest"`.
+func parsePipeline(expr string) (string, []filterCall, error) {
+	stages, err := splitPipes(expr)
+	if err != nil {
+		return "", nil, err
+	}
+	var calls []filterCall
+	for _, stage := range stages[1:] {
+		args, err := splitArgs(stage)
+		if err != nil {
+			return "", nil, err
+		}
+		calls = append(calls, filterCall{name: args[0], args: args[1:]})
+	}
+	return strings.TrimSpace(stages[0]), calls, nil
+}
+
+func hasDefault(calls []filterCall) bool {
+	for _, call := range calls {
+		if call.name == defaultFilter {
+			return true
+		}
+	}
+	return false
+}
+
+// splitPipes splits expr on the `|` that are not quoted.
+func splitPipes(expr string) ([]string, error) {
+	var stages []string
+	start := 0
+	for i := 0; i < len(expr); i++ {
+		switch expr[i] {
+		case '"':
+			quoted, err := strconv.QuotedPrefix(expr[i:])
+			if err != nil {
+				return nil, fmt.Errorf("unterminated string in %s", expr[i:])
+			}
+			i += len(quoted) - 1
+		case '|':
+			stages = append(stages, expr[start:i])
+			start = i + 1
+		}
+	}
+	return append(stages, expr[start:]), nil
+}
+
+// withFilters returns the lookup applying the filters of the expressions to the values
+// returned by lookup. The filters of the values that cannot be resolved are skipped
+// until a default filter gives them a value.
+func withFilters(lookup lookupFunc) lookupFunc {
+	return func(expr string) (string, error) {
+		if !strings.Contains(expr, "|") {
+			return lookup(expr)
+		}
+		name, calls, err := parsePipeline(expr)
+		if err != nil {
+			return "", err
+		}
+		value, lookupErr := lookup(name)
+		for _, call := range calls {
+			fn, ok := filter(call.name)
+			if !ok {
+				return "", fmt.Errorf("unknown filter %s", call.name)
+			}
+			if lookupErr != nil && call.name != defaultFilter {
+				continue
+			}
+			if value, err = fn(value, call.args...); err != nil {
+				return "", fmt.Errorf("%s: %w", call.name, err)
+			}
+			lookupErr = nil
+		}
+		return value, lookupErr
+	}
+}
+
+// defaultFunc gives its argument as the value of unresolved and empty variables.
+func defaultFunc(value string, args ...string) (string, error) {
+	if err := expectArgs(args, 1, 1); err != nil {
+		return "", err
+	}
+	if value == "" {
+		return args[0], nil
+	}
+	return value, nil
+}
+
+// base64Func encodes the value in standard base64, or in URL-safe base64 with the
+// `url` argument.
+func base64Func(value string, args ...string) (string, error) {
+	if err := expectArgs(args, 0, 1); err != nil {
+		return "", err
+	}
+	if len(args) == 0 {
+		return base64.StdEncoding.EncodeToString([]byte(value)), nil
+	}
+	if args[0] != "url" {
+		return "", fmt.Errorf("unknown encoding %s", args[0])
+	}
+	return base64.URLEncoding.EncodeToString([]byte(value)), nil
+}
+
+// urlEncodeFunc escapes the value to be placed in a URL query.
+func urlEncodeFunc(value string, args ...string) (string, error) {
+	if err := expectArgs(args, 0, 0); err != nil {
+		return "", err
+	}
+	return url.QueryEscape(value), nil
+}
+
+// jsonFunc writes the value as a quoted JSON string.
+func jsonFunc(value string, args ...string) (string, error) {
+	if err := expectArgs(args, 0, 0); err != nil {
+		return "", err
+	}
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buffer.String(), "\n"), nil
+}
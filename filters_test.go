@@ -0,0 +1,78 @@
+package rq
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFilters(t *testing.T) {
+	ctx := WithEnvironment(context.Background(), map[string]string{
+		"name":  "Fred & Wilma",
+		"token": "user:secret",
+		"empty": "",
+		"quote": `say "hi" <b>`,
+	})
+
+	t.Run("Filters are applied to the values", func(t *testing.T) {
+		for _, test := range []struct {
+			template string
+			expected string
+		}{
+			{`{{name | default "guest"}}`, "Fred & Wilma"},
+			{`{{missing | default "guest"}}`, "guest"},
+			{`{{empty | default "guest"}}`, "guest"},
+			{`{{missing | default "a | b"}}`, "a | b"},
+			{"{{token | base64}}", "dXNlcjpzZWNyZXQ="},
+			{"{{ token|base64 url }}", "dXNlcjpzZWNyZXQ="},
+			{"{{name | urlencode}}", "Fred+%26+Wilma"},
+			{"{{quote | json}}", `"say \"hi\" <b>"`},
+			{`{{missing | urlencode | default "a b" | urlencode}}`, "a+b"},
+			{`{{$processEnv RQ_FILTERS_UNSET | default "none"}}`, "none"},
+			{"{{missing | base64}}", "{{missing | base64}}"},
+			{"{{name | unknown}}", "{{name | unknown}}"},
+			{"{{name | default}}", "{{name | default}}"},
+		} {
+			request := Request{Method: "GET", URL: test.template}
+			if got := applyEnv(t, ctx, request).URL; got != test.expected {
+				t.Errorf("%s: expected %q, got %q", test.template, test.expected, got)
+			}
+		}
+	})
+
+	t.Run("Filters are applied to file variables", func(t *testing.T) {
+		request := Request{
+			Method:    "GET",
+			URL:       "/{{user}}",
+			Variables: map[string]string{"user": "{{login | default \"me\" | urlencode}}", "login": "a b"},
+		}
+		if diff := cmp.Diff("/a+b", applyEnv(t, ctx, request).URL); diff != "" {
+			t.Errorf("url mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Failing filters are reported in strict mode", func(t *testing.T) {
+		request := Request{Method: "GET", URL: "{{missing | base64}}/{{name | unknown}}"}
+		_, err := request.ApplyEnv(WithStrictVariables(ctx))
+		expected := "unresolved variables: {{missing | base64}} in URL, {{name | unknown}} in URL (unknown filter unknown)"
+		if err == nil || err.Error() != expected {
+			t.Errorf("expected %q, got %v", expected, err)
+		}
+	})
+
+	t.Run("Filters can be registered", func(t *testing.T) {
+		RegisterFilter("upper", func(value string, args ...string) (string, error) {
+			if len(args) > 0 {
+				return "", errors.New("unexpected arguments")
+			}
+			return strings.ToUpper(value), nil
+		})
+		request := Request{Method: "GET", URL: `/{{name | upper | urlencode}}`}
+		if diff := cmp.Diff("/FRED+%26+WILMA", applyEnv(t, ctx, request).URL); diff != "" {
+			t.Errorf("url mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
@@ -0,0 +1,180 @@
+package rq
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/textproto"
+	"strings"
+
+	"github.com/go-rq/rq/ast"
+)
+
+// scriptIndent is the indentation of the lines of multi-line scripts.
+const scriptIndent = "    "
+
+// Format returns the canonical formatting of the .http file src. Comments are
+// preserved and the following is normalised:
+//   - separators are written as `### <name>` and preceded by a single blank line
+//   - runs of blank lines are collapsed and a blank line separates the headers from the body
+//   - header names are canonicalised and written as `Key: value`
+//   - multi-line scripts are written with `< {%` and `%}` on their own lines
+//   - JSON bodies are indented with two spaces
+//
+// An error is returned when the file contains invalid requests, see ParseRequests.
+func Format(src []byte) ([]byte, error) {
+	f := ast.ParseFile(src)
+	p := &parser{position: f.Position}
+	for _, block := range f.Requests {
+		if block.Bad != nil {
+			p.errorAt(block.Bad.Pos(), block.Bad.Raw, fmt.Errorf("%w: %s", ErrInvalidRequest, block.Bad.Reason))
+		}
+	}
+	if err := p.errs.err(); err != nil {
+		return nil, err
+	}
+	var buffer bytes.Buffer
+	for _, block := range f.Requests {
+		formatBlock(&buffer, block)
+	}
+	// the blank line closing the last block is dropped
+	return append(bytes.TrimRight(buffer.Bytes(), "\n"), '\n'), nil
+}
+
+func formatBlock(buffer *bytes.Buffer, block *ast.Request) {
+	start := buffer.Len()
+	// blank is set when a blank line should be written before the next node
+	blank := false
+	jsonBody := isJSON(block)
+	for _, node := range block.Nodes {
+		switch node.(type) {
+		case *ast.Blank:
+			blank = buffer.Len() > start
+			continue
+		case *ast.Body, *ast.BodyFile:
+			blank = true
+		case *ast.Script:
+			blank = blank || node.(*ast.Script).Post
+		}
+		if _, ok := node.(*ast.Separator); !ok && blank {
+			buffer.WriteString("\n")
+		}
+		blank = false
+		formatNode(buffer, node, jsonBody)
+	}
+	if buffer.Len() > start {
+		buffer.WriteString("\n")
+	}
+}
+
+func formatNode(buffer *bytes.Buffer, node ast.Node, jsonBody bool) {
+	switch node := node.(type) {
+	case *ast.Separator:
+		buffer.WriteString(strings.TrimSpace(RequestSeparator + " " + node.Name.Value))
+	case *ast.Comment:
+		buffer.WriteString(node.Marker + strings.TrimRight(node.Text.Value, " \t"))
+	case *ast.Annotation:
+		fmt.Fprintf(buffer, "%s @%s", node.Marker, node.Key.Value)
+		if node.Value.Value != "" {
+			buffer.WriteString(" " + node.Value.Value)
+		}
+	case *ast.Variable:
+		fmt.Fprintf(buffer, "@%s = %s", node.Name.Value, node.Value.Value)
+	case *ast.RequestLine:
+		buffer.WriteString(strings.Join(nonEmpty(node.Method.Value, node.URL.Value, node.Proto.Value), " "))
+	case *ast.URLContinuation:
+		buffer.WriteString(scriptIndent + node.Text.Value)
+	case *ast.Header:
+		fmt.Fprintf(buffer, "%s: %s", textproto.CanonicalMIMEHeaderKey(node.Key.Value), node.Value.Value)
+	case *ast.Script:
+		if node.Path.Value != "" {
+			buffer.WriteString("< " + node.Path.Value)
+		} else {
+			buffer.WriteString(formatScript(node.Code.Value))
+		}
+	case *ast.Body:
+		buffer.WriteString(strings.TrimSuffix(formatBody(node.Value, jsonBody), "\n"))
+	case *ast.BodyFile:
+		if node.Substitute {
+			buffer.WriteString("<@ " + node.Path.Value)
+		} else {
+			buffer.WriteString("< " + node.Path.Value)
+		}
+	case *ast.ResponseRedirect:
+		if node.Overwrite {
+			buffer.WriteString(">>! " + node.Path.Value)
+		} else {
+			buffer.WriteString(">> " + node.Path.Value)
+		}
+	}
+	buffer.WriteString("\n")
+}
+
+// formatScript writes the script on a single line when it has a single line of code,
+// otherwise its lines are indented between the `< {%` and `%}` lines, keeping their
+// relative indentation.
+func formatScript(code string) string {
+	lines := strings.Split(strings.Trim(strings.ReplaceAll(code, "\r", ""), "\n"), "\n")
+	if len(lines) == 1 {
+		return fmt.Sprintf("< {%% %s %%}", strings.TrimSpace(lines[0]))
+	}
+	indent := -1
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if n := len(line) - len(strings.TrimLeft(line, " \t")); indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	var builder strings.Builder
+	builder.WriteString("< {%\n")
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t")
+		if line == "" {
+			builder.WriteString("\n")
+			continue
+		}
+		builder.WriteString(scriptIndent + line[indent:] + "\n")
+	}
+	builder.WriteString("%}")
+	return builder.String()
+}
+
+// formatBody indents JSON bodies, other bodies are left untouched.
+func formatBody(body string, isJSON bool) string {
+	trimmed := strings.TrimSpace(body)
+	if !isJSON || !json.Valid([]byte(trimmed)) {
+		return body
+	}
+	var buffer bytes.Buffer
+	if err := json.Indent(&buffer, []byte(trimmed), "", "  "); err != nil {
+		return body
+	}
+	return buffer.String() + "\n"
+}
+
+// isJSON reports whether the body of the request is JSON, either by its Content-Type
+// or, without one, by its content.
+func isJSON(block *ast.Request) bool {
+	for _, header := range block.Headers {
+		if strings.EqualFold(header.Key.Value, "Content-Type") {
+			return strings.Contains(header.Value.Value, "json")
+		}
+	}
+	if block.Body == nil {
+		return false
+	}
+	body := strings.TrimSpace(block.Body.Value)
+	return strings.HasPrefix(body, "{") || strings.HasPrefix(body, "[")
+}
+
+func nonEmpty(values ...string) []string {
+	var result []string
+	for _, value := range values {
+		if value != "" {
+			result = append(result, value)
+		}
+	}
+	return result
+}
@@ -0,0 +1,239 @@
+package rq
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+const unformatted = `# Users API
+@host = http://localhost
+
+
+###   Get User
+# @tag smoke
+//@no-redirect
+<{%   setEnv('id', '1')   %}
+GET {{host}}/users/{{id}}  HTTP/1.1
+  ?fields=name
+      &sort=asc
+accept:application/json
+// Accept: text/plain
+x-request-id :  42
+< {%
+        assert(response.statusCode === 200, 'ok')
+          client.log(response.body)
+%}
+>>!   ./out/user.json
+### Create User
+POST {{host}}/users
+content-type: application/json
+
+
+
+{"name": "Fred", "roles": ["admin"]}
+
+
+
+< {%assert(response.statusCode === 201, 'created')%}
+###
+{{host}}/health
+###Upload
+PUT {{host}}/avatar
+
+<@   ./avatar.png
+`
+
+const formatted = `# Users API
+@host = http://localhost
+
+### Get User
+# @tag smoke
+// @no-redirect
+< {% setEnv('id', '1') %}
+GET {{host}}/users/{{id}} HTTP/1.1
+    ?fields=name
+    &sort=asc
+Accept: application/json
+// Accept: text/plain
+X-Request-Id: 42
+
+< {%
+    assert(response.statusCode === 200, 'ok')
+      client.log(response.body)
+%}
+>>! ./out/user.json
+
+### Create User
+POST {{host}}/users
+Content-Type: application/json
+
+{
+  "name": "Fred",
+  "roles": [
+    "admin"
+  ]
+}
+
+< {% assert(response.statusCode === 201, 'created') %}
+
+###
+{{host}}/health
+
+### Upload
+PUT {{host}}/avatar
+
+<@ ./avatar.png
+`
+
+func TestFormat(t *testing.T) {
+	t.Run("The file is formatted", func(t *testing.T) {
+		result, err := Format([]byte(unformatted))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(formatted, string(result)); diff != "" {
+			t.Errorf("format mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Formatting is idempotent", func(t *testing.T) {
+		result, err := Format([]byte(formatted))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(formatted, string(result)); diff != "" {
+			t.Errorf("format mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Formatting preserves the requests", func(t *testing.T) {
+		fsys := fstest.MapFS{"avatar.png": {Data: []byte("png")}}
+		assertRoundTrip(t, fsys, unformatted)
+	})
+
+	t.Run("Invalid requests are reported", func(t *testing.T) {
+		_, err := Format([]byte("GET /users\n### Broken\nnot a request\n"))
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) || !errors.Is(err, ErrInvalidRequest) {
+			t.Fatalf("expected a parse error, got %v", err)
+		}
+		if parseErr.Line != 3 {
+			t.Errorf("expected the error on line 3, got %d", parseErr.Line)
+		}
+	})
+
+	t.Run("Carriage returns are normalised", func(t *testing.T) {
+		result, err := Format([]byte("GET /users\r\nAccept: text/plain\r\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff("GET /users\nAccept: text/plain\n", string(result)); diff != "" {
+			t.Errorf("format mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestFormat_testdata(t *testing.T) {
+	fsys := os.DirFS("testdata")
+	err := fs.WalkDir(fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || filepath.Ext(name) != ".http" {
+			return err
+		}
+		t.Run(name, func(t *testing.T) {
+			src, err := fs.ReadFile(fsys, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sub, err := fs.Sub(fsys, filepath.Dir(name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertRoundTrip(t, sub, string(src))
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRequest_String_roundTrip(t *testing.T) {
+	fsys := fstest.MapFS{"avatar.png": {Data: []byte("png")}}
+	requests, err := ParseRequests(formatted, WithFS(fsys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, request := range requests {
+		t.Run(request.Name, func(t *testing.T) {
+			parsed, err := ParseRequests(request.String(), WithFS(fsys))
+			if err != nil {
+				t.Fatalf("parsing %q: %v", request.String(), err)
+			}
+			if len(parsed) != 1 {
+				t.Fatalf("expected 1 request, got %d", len(parsed))
+			}
+			if diff := cmp.Diff(request, parsed[0], cmpopts.IgnoreFields(Request{}, "Variables", "Line")); diff != "" {
+				t.Errorf("request mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// assertRoundTrip asserts that src and its formatting parse to the same requests and
+// that formatting the formatted source does not change it.
+func assertRoundTrip(t *testing.T, fsys fs.FS, src string) {
+	t.Helper()
+	result, err := Format([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := Format(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(string(result), string(again)); diff != "" {
+		t.Errorf("formatting is not idempotent (-want +got):\n%s", diff)
+	}
+	expected, err := ParseRequests(src, WithFS(fsys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := ParseRequests(string(result), WithFS(fsys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(normalize(expected), normalize(actual)); diff != "" {
+		t.Errorf("requests mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// normalize undoes the changes of the formatter that do not change the meaning of
+// the requests: the lines of the requests, the casing of header names and the
+// indentation of JSON bodies.
+func normalize(requests []Request) []Request {
+	for i := range requests {
+		requests[i].Line = 0
+		headers := make(Headers, len(requests[i].Headers))
+		for j, header := range requests[i].Headers {
+			headers[j] = Header{Key: textproto.CanonicalMIMEHeaderKey(header.Key), Value: header.Value}
+		}
+		requests[i].Headers = headers
+		var buffer bytes.Buffer
+		if err := json.Compact(&buffer, []byte(requests[i].Body)); err == nil {
+			requests[i].Body = buffer.String()
+		}
+		requests[i].Body = strings.TrimSpace(requests[i].Body)
+	}
+	return requests
+}
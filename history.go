@@ -0,0 +1,217 @@
+package rq
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrResponseNotFound is matched with errors.Is by the errors of the templates that
+// reference the response of a request that has not run, see History.
+var ErrResponseNotFound = errors.New("response not found")
+
+// History records the responses of the named requests of a run, so that templates can
+// reference them by the name of the request:
+//   - `{{login.response.body}}` is the body of the response
+//   - `{{login.response.body.$.access_token}}` is a value of a JSON body
+//   - `{{login.response.headers.Location}}` is the value of a header
+//
+// The bodies of the recorded responses are kept in memory for the lifetime of the
+// History, a History created with NewHistoryFor only records the responses that are
+// referenced. A History is safe for concurrent use.
+type History struct {
+	mu        sync.RWMutex
+	responses map[string]recordedResponse
+	// referenced holds the names of the requests whose responses are recorded, all
+	// responses are recorded when it is nil
+	referenced map[string]bool
+}
+
+// recordedResponse is a response with its body read.
+type recordedResponse struct {
+	resp *Response
+	body []byte
+}
+
+// NewHistory returns an empty history recording the responses of all the named requests.
+func NewHistory() *History {
+	return &History{responses: map[string]recordedResponse{}}
+}
+
+// NewHistoryFor returns an empty history recording only the responses referenced by the
+// templates of requests, or of the requests given to Reference later on. The responses
+// of the other requests are left unread.
+func NewHistoryFor(requests []Request) *History {
+	h := &History{responses: map[string]recordedResponse{}, referenced: map[string]bool{}}
+	h.Reference(requests...)
+	return h
+}
+
+// Reference records the responses referenced by the templates of requests from now on,
+// in addition to the ones referenced so far. It has no effect on a history created with
+// NewHistory, which records all the responses.
+func (h *History) Reference(requests ...Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.referenced == nil {
+		return
+	}
+	for _, req := range requests {
+		texts := requestTemplates(req)
+		for _, value := range req.Variables {
+			texts = append(texts, value)
+		}
+		for _, text := range texts {
+			for _, match := range variableRegexp.FindAllStringSubmatch(text, -1) {
+				name, _, err := parsePipeline(match[1])
+				if ref, _, ok := strings.Cut(name, ".response."); err == nil && ok {
+					h.referenced[ref] = true
+				}
+			}
+		}
+	}
+}
+
+// Response returns the last response of the request name.
+func (h *History) Response(name string) (*Response, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	recorded, ok := h.responses[name]
+	if !ok {
+		return nil, false
+	}
+	raw := *recorded.resp.Response
+	raw.Body = io.NopCloser(bytes.NewReader(recorded.body))
+	return &Response{Response: &raw, PostRequestAssertions: recorded.resp.PostRequestAssertions}, true
+}
+
+// record adds the response of the request name to the history, its body is read and
+// replaced to be read again by the caller. Responses that are not referenced are left
+// untouched.
+func (h *History) record(name string, resp *Response) error {
+	h.mu.RLock()
+	skip := h.referenced != nil && !h.referenced[name]
+	h.mu.RUnlock()
+	if skip {
+		return nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.responses[name] = recordedResponse{resp: resp, body: body}
+	return nil
+}
+
+// resolve returns the value of a reference to the response of the request name, path
+// is the part of the reference following `.response.`, ex., `body.$.id`.
+func (h *History) resolve(name, path string) (string, error) {
+	var recorded recordedResponse
+	ok := false
+	if h != nil {
+		h.mu.RLock()
+		recorded, ok = h.responses[name]
+		h.mu.RUnlock()
+	}
+	if !ok {
+		return "", fmt.Errorf("%w: request %q has not run", ErrResponseNotFound, name)
+	}
+	switch part, rest, _ := strings.Cut(path, "."); part {
+	case "body":
+		if rest == "" {
+			return string(recorded.body), nil
+		}
+		var data any
+		if err := json.Unmarshal(recorded.body, &data); err != nil {
+			return "", fmt.Errorf("the body of %q is not JSON", name)
+		}
+		value, err := jsonPath(data, rest)
+		if err != nil {
+			return "", fmt.Errorf("%s of %q: %w", rest, name, err)
+		}
+		return formatValue(value), nil
+	case "headers":
+		values := recorded.resp.Header.Values(rest)
+		if len(values) == 0 {
+			return "", fmt.Errorf("the response of %q has no %s header", name, rest)
+		}
+		return strings.Join(values, ", "), nil
+	default:
+		return "", fmt.Errorf("unknown response property %s, expected body or headers", part)
+	}
+}
+
+// jsonPath returns the value of data at path, a JSONPath made of `$` followed by
+// `.key`, `['key']` and `[index]` selectors.
+func jsonPath(data any, path string) (any, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("invalid JSONPath %s", path)
+	}
+	for rest := path[1:]; rest != ""; {
+		var key string
+		switch {
+		case rest[0] == '.':
+			end := strings.IndexAny(rest[1:], ".[") + 1
+			if end == 0 {
+				end = len(rest)
+			}
+			key, rest = rest[1:end], rest[end:]
+		case strings.HasPrefix(rest, "['") || strings.HasPrefix(rest, `["`):
+			end := strings.Index(rest[2:], string(rest[1])+"]")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated selector %s", rest)
+			}
+			key, rest = rest[2:end+2], rest[end+4:]
+		case rest[0] == '[':
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated selector %s", rest)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("invalid index %s", rest[1:end])
+			}
+			rest = rest[end+1:]
+			values, ok := data.([]any)
+			if !ok || index < 0 || index >= len(values) {
+				return nil, fmt.Errorf("no value at index %d", index)
+			}
+			data = values[index]
+			continue
+		default:
+			return nil, fmt.Errorf("invalid JSONPath %s", path)
+		}
+		object, ok := data.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("no value at key %s", key)
+		}
+		if data, ok = object[key]; !ok {
+			return nil, fmt.Errorf("no value at key %s", key)
+		}
+	}
+	return data, nil
+}
+
+type historyContextKey struct{}
+
+// WithHistory records the responses of the named requests run with the context in h,
+// their templates can then reference the responses of the requests run before them.
+func WithHistory(ctx context.Context, h *History) context.Context {
+	return context.WithValue(ctx, historyContextKey{}, h)
+}
+
+// GetHistory returns the history of the context, nil when the context has none.
+func GetHistory(ctx context.Context) *History {
+	h, _ := ctx.Value(historyContextKey{}).(*History)
+	return h
+}
@@ -0,0 +1,137 @@
+package rq
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHistory(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Location", "/users/1")
+			w.Write([]byte(`{"access_token": "abc", "user": {"roles": ["admin", "dev"], "first name": "Fred"}}`))
+		default:
+			w.Write([]byte(r.Header.Get("Authorization") + " " + r.URL.RawQuery))
+		}
+	}))
+	defer srv.Close()
+	env := map[string]string{"host": srv.URL}
+
+	t.Run("Templates reference the responses of previous requests", func(t *testing.T) {
+		ctx := WithHistory(WithEnvironment(context.Background(), env), NewHistory())
+		login := Request{Name: "login", Method: "POST", URL: "{{host}}/login"}
+		resp, err := login.Do(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body, _ := io.ReadAll(resp.Body); len(body) == 0 {
+			t.Error("expected the body of the response to be readable")
+		}
+		request := Request{
+			Method:  "GET",
+			URL:     "{{host}}{{login.response.headers.Location}}?role={{login.response.body.$.user.roles[1]}}&name={{login.response.body.$.user['first name'] | urlencode}}",
+			Headers: Headers{{Key: "Authorization", Value: "Bearer {{login.response.body.$.access_token}}"}},
+		}
+		resp, err = request.Do(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if diff := cmp.Diff("Bearer abc role=dev&name=Fred", string(body)); diff != "" {
+			t.Errorf("body mismatch (-want +got):\n%s", diff)
+		}
+		if diff := cmp.Diff("/users/1", resp.Request.URL.Path); diff != "" {
+			t.Errorf("path mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Responses are read from the history", func(t *testing.T) {
+		history := NewHistory()
+		ctx := WithHistory(WithEnvironment(context.Background(), env), history)
+		login := Request{Name: "login", Method: "POST", URL: "{{host}}/login"}
+		if _, err := login.Do(ctx); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			resp, ok := history.Response("login")
+			if !ok {
+				t.Fatal("expected the response of login")
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != http.StatusOK || len(body) == 0 {
+				t.Errorf("unexpected response %d %q", resp.StatusCode, body)
+			}
+		}
+		if _, ok := history.Response("logout"); ok {
+			t.Error("expected no response for logout")
+		}
+	})
+
+	t.Run("Only referenced responses are recorded", func(t *testing.T) {
+		login := Request{Name: "login", Method: "POST", URL: "{{host}}/login"}
+		logout := Request{Name: "logout", Method: "POST", URL: "{{host}}/login"}
+		user := Request{Method: "GET", URL: "{{host}}/users", Variables: map[string]string{"token": "{{login.response.body.$.access_token | upper}}"}}
+		history := NewHistoryFor([]Request{login, logout, user})
+		ctx := WithHistory(WithEnvironment(context.Background(), env), history)
+		for _, req := range []Request{login, logout} {
+			resp, err := req.Do(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if body, _ := io.ReadAll(resp.Body); len(body) == 0 {
+				t.Errorf("expected the body of %s to be readable", req.Name)
+			}
+		}
+		if _, ok := history.Response("login"); !ok {
+			t.Error("expected the response of login")
+		}
+		if _, ok := history.Response("logout"); ok {
+			t.Error("expected no response for logout")
+		}
+		history.Reference(Request{Method: "GET", URL: "{{logout.response.headers.Location}}"})
+		if _, err := logout.Do(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := history.Response("logout"); !ok {
+			t.Error("expected the response of logout once referenced")
+		}
+	})
+
+	t.Run("Requests referencing requests that have not run fail", func(t *testing.T) {
+		ctx := WithHistory(WithEnvironment(context.Background(), env), NewHistory())
+		request := Request{Method: "GET", URL: "{{host}}/users?token={{login.response.body.$.access_token}}&{{missing}}"}
+		_, err := request.Do(ctx)
+		if !errors.Is(err, ErrResponseNotFound) {
+			t.Fatalf("expected a response not found error, got %v", err)
+		}
+		expected := `unresolved variables: {{login.response.body.$.access_token}} in URL (response not found: request "login" has not run)`
+		if diff := cmp.Diff(expected, err.Error()); diff != "" {
+			t.Errorf("message mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Invalid references are reported in strict mode", func(t *testing.T) {
+		ctx := WithHistory(WithEnvironment(context.Background(), env), NewHistory())
+		login := Request{Name: "login", Method: "POST", URL: "{{host}}/login"}
+		if _, err := login.Do(ctx); err != nil {
+			t.Fatal(err)
+		}
+		request := Request{Method: "GET", URL: "{{login.response.body.$.user.id}} {{login.response.headers.X-Id}} {{login.response.status}} {{login.response.body.$.user.roles[2]}}"}
+		_, err := request.ApplyEnv(WithStrictVariables(ctx))
+		expected := `unresolved variables: {{login.response.body.$.user.id}} in URL ($.user.id of "login": no value at key id), ` +
+			`{{login.response.headers.X-Id}} in URL (the response of "login" has no X-Id header), ` +
+			`{{login.response.status}} in URL (unknown response property status, expected body or headers), ` +
+			`{{login.response.body.$.user.roles[2]}} in URL ($.user.roles[2] of "login": no value at index 2)`
+		if err == nil || err.Error() != expected {
+			t.Errorf("expected %q, got %v", expected, err)
+		}
+	})
+}
@@ -0,0 +1,344 @@
+package rq
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/go-rq/rq/ast"
+)
+
+// Diagnostic is an issue reported by a lint rule.
+type Diagnostic struct {
+	// File, Line and Column locate the issue, Column is 0 when the issue concerns the
+	// request as a whole and Line is then its request line.
+	File   string
+	Line   int
+	Column int
+	// EndColumn is the column following the offending text, on Line.
+	EndColumn int
+	// Request is the name of the request.
+	Request string
+	// Rule is the name of the rule reporting the issue.
+	Rule    string
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	file := d.File
+	if file == "" {
+		file = "<input>"
+	}
+	if d.Column > 0 {
+		return fmt.Sprintf("%s:%d:%d: %s (%s)", file, d.Line, d.Column, d.Message, d.Rule)
+	}
+	return fmt.Sprintf("%s:%d: %s (%s)", file, d.Line, d.Message, d.Rule)
+}
+
+// LintRule checks the requests of a collection and reports the issues it finds to
+// the pass.
+type LintRule struct {
+	// Name identifies the rule in diagnostics and in the @no-lint annotation.
+	Name string
+	// Doc is a short description of what the rule checks.
+	Doc   string
+	Check func(pass *LintPass)
+}
+
+// LintPass provides a rule with the requests and environment being checked.
+type LintPass struct {
+	Requests    []Request
+	Environment map[string]string
+	// Source is the syntax tree of the file the requests were parsed from, it is nil
+	// unless given with WithLintSource.
+	Source *ast.File
+
+	rule        LintRule
+	diagnostics []Diagnostic
+}
+
+// Reportf reports an issue of the request.
+func (p *LintPass) Reportf(req Request, format string, args ...any) {
+	if suppressed(req, p.rule.Name) {
+		return
+	}
+	p.diagnostics = append(p.diagnostics, Diagnostic{
+		File:    req.File,
+		Line:    req.Line,
+		Request: req.Name,
+		Rule:    p.rule.Name,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// ReportAtf reports an issue of the request at the first occurrence of text in the
+// source of the request, or on its request line when the source is unknown or does
+// not contain text, ex., when it comes from a body file.
+func (p *LintPass) ReportAtf(req Request, text string, format string, args ...any) {
+	n := len(p.diagnostics)
+	p.Reportf(req, format, args...)
+	if len(p.diagnostics) == n {
+		return
+	}
+	if from, ok := p.find(req, text); ok {
+		start, end := p.Source.Position(from), p.Source.Position(from+ast.Pos(len(text)))
+		diagnostic := &p.diagnostics[n]
+		diagnostic.Line, diagnostic.Column, diagnostic.EndColumn = start.Line, start.Column, end.Column
+		if end.Line != start.Line {
+			diagnostic.EndColumn = 0
+		}
+	}
+}
+
+// find returns the offset of the first occurrence of text in the request line, URL,
+// headers, body and response redirect of the block of the request.
+func (p *LintPass) find(req Request, text string) (ast.Pos, bool) {
+	if p.Source == nil || text == "" {
+		return 0, false
+	}
+	for _, block := range p.Source.Requests {
+		if block.Line == nil || p.Source.Position(block.Line.Pos()).Line != req.Line {
+			continue
+		}
+		for _, node := range block.Nodes {
+			switch node.(type) {
+			case *ast.RequestLine, *ast.URLContinuation, *ast.Header, *ast.Body, *ast.ResponseRedirect:
+				if i := strings.Index(ast.Source(node), text); i >= 0 {
+					return node.Pos() + ast.Pos(i), true
+				}
+			}
+		}
+	}
+	return 0, false
+}
+
+// suppressed reports whether the rule is disabled for the request with @no-lint.
+func suppressed(req Request, rule string) bool {
+	value, ok := req.Annotations[AnnotationNoLint]
+	if !ok {
+		return false
+	}
+	rules := strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' })
+	if len(rules) == 0 {
+		return true
+	}
+	return slices.Contains(rules, rule)
+}
+
+// LintOption configures Lint.
+type LintOption func(*lintOptions)
+
+type lintOptions struct {
+	rules  []LintRule
+	source *ast.File
+}
+
+// WithLintRules replaces the rules checked by Lint, which default to DefaultLintRules.
+func WithLintRules(rules ...LintRule) LintOption {
+	return func(o *lintOptions) {
+		o.rules = rules
+	}
+}
+
+// WithoutLintRules disables the rules with the given names.
+func WithoutLintRules(names ...string) LintOption {
+	return func(o *lintOptions) {
+		var rules []LintRule
+		for _, rule := range o.rules {
+			if !slices.Contains(names, rule.Name) {
+				rules = append(rules, rule)
+			}
+		}
+		o.rules = rules
+	}
+}
+
+// WithLintSource gives the rules the syntax tree of the file the requests were parsed
+// from, so that issues are located at the offending text rather than on the request
+// line. The requests must all come from that file.
+func WithLintSource(file *ast.File) LintOption {
+	return func(o *lintOptions) {
+		o.source = file
+	}
+}
+
+// Lint checks the requests of a collection against the rules, DefaultLintRules unless
+// WithLintRules is given, and returns the issues found sorted by position. The
+// variables of env are considered defined, as are file variables and the variables
+// set by the scripts of the requests.
+func Lint(requests []Request, env map[string]string, options ...LintOption) []Diagnostic {
+	opts := &lintOptions{rules: DefaultLintRules()}
+	for _, option := range options {
+		option(opts)
+	}
+	var diagnostics []Diagnostic
+	for _, rule := range opts.rules {
+		pass := &LintPass{Requests: requests, Environment: env, Source: opts.source, rule: rule}
+		rule.Check(pass)
+		diagnostics = append(diagnostics, pass.diagnostics...)
+	}
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		if diagnostics[i].File != diagnostics[j].File {
+			return diagnostics[i].File < diagnostics[j].File
+		}
+		if diagnostics[i].Line != diagnostics[j].Line {
+			return diagnostics[i].Line < diagnostics[j].Line
+		}
+		return diagnostics[i].Column < diagnostics[j].Column
+	})
+	return diagnostics
+}
+
+// DefaultLintRules returns the rules checked by Lint by default.
+func DefaultLintRules() []LintRule {
+	return []LintRule{
+		UndefinedVariableRule,
+		DuplicateNameRule,
+		BodyOnGetRule,
+		JSONContentTypeRule,
+		ResponseInPreScriptRule,
+	}
+}
+
+var (
+	// setEnvRegexp matches the variables set by scripts, ex., `setEnv('token', ...)`.
+	setEnvRegexp = regexp.MustCompile(`(?:setEnv|setSecret)\(\s*['"` + "`" + `]([^'"` + "`" + `]+)['"` + "`" + `]|environment\.(\w+)\s*=[^=]|environment\[\s*['"]([^'"]+)['"]\s*\]\s*=[^=]`)
+	// responseRegexp matches references to the response in scripts.
+	responseRegexp = regexp.MustCompile(`\bresponse\b`)
+)
+
+// UndefinedVariableRule reports the {{variables}} that are neither in the environment,
+// file variables nor set by the scripts of the request or of the requests before it,
+// unless they are given a default value with the default filter, and the references to
+// the responses of requests that do not run before the request.
+var UndefinedVariableRule = LintRule{
+	Name: "undefined-variable",
+	Doc:  "report {{variables}} that are never defined",
+	Check: func(pass *LintPass) {
+		defined, ran := map[string]bool{}, map[string]bool{}
+		for key := range pass.Environment {
+			defined[key] = true
+		}
+		for _, req := range pass.Requests {
+			scriptVariables(req.PreRequestScript, defined)
+			reported := map[string]bool{}
+			for _, text := range requestTemplates(req) {
+				for _, match := range variableRegexp.FindAllStringSubmatch(text, -1) {
+					name, calls, err := parsePipeline(match[1])
+					if err != nil || hasDefault(calls) {
+						continue
+					}
+					if _, ok := req.Variables[name]; ok || defined[name] || reported[name] || strings.HasPrefix(name, "$") {
+						continue
+					}
+					reported[name] = true
+					if ref, _, ok := strings.Cut(name, ".response."); ok {
+						if !ran[ref] {
+							pass.ReportAtf(req, match[0], "{{%s}} references request %q, which does not run before", name, ref)
+						}
+						continue
+					}
+					pass.ReportAtf(req, match[0], "undefined variable {{%s}}", name)
+				}
+			}
+			scriptVariables(req.PostRequestScript, defined)
+			if req.Name != "" {
+				ran[req.Name] = true
+			}
+		}
+	},
+}
+
+// DuplicateNameRule reports requests sharing the name of a previous request of the
+// same file.
+var DuplicateNameRule = LintRule{
+	Name: "duplicate-name",
+	Doc:  "report requests with the same name in a file",
+	Check: func(pass *LintPass) {
+		lines := map[[2]string]int{}
+		for _, req := range pass.Requests {
+			if req.Name == "" {
+				continue
+			}
+			key := [2]string{req.File, req.Name}
+			if line, ok := lines[key]; ok {
+				pass.Reportf(req, "duplicate request name %q, first used on line %d", req.Name, line)
+				continue
+			}
+			lines[key] = req.Line
+		}
+	},
+}
+
+// BodyOnGetRule reports GET and HEAD requests with a body, which servers may ignore.
+var BodyOnGetRule = LintRule{
+	Name: "body-on-get",
+	Doc:  "report GET and HEAD requests with a body",
+	Check: func(pass *LintPass) {
+		for _, req := range pass.Requests {
+			if (req.Method == http.MethodGet || req.Method == http.MethodHead) && (req.Body != "" || req.BodyFile != "") {
+				pass.Reportf(req, "%s request with a body", req.Method)
+			}
+		}
+	},
+}
+
+// JSONContentTypeRule reports requests with a JSON body and no Content-Type header.
+var JSONContentTypeRule = LintRule{
+	Name: "json-content-type",
+	Doc:  "report JSON bodies sent without a Content-Type header",
+	Check: func(pass *LintPass) {
+		for _, req := range pass.Requests {
+			body := strings.TrimSpace(req.Body)
+			if req.Headers.Get("Content-Type") != "" || !(strings.HasPrefix(body, "{") || strings.HasPrefix(body, "[")) {
+				continue
+			}
+			// variables are replaced with a valid JSON value to validate templates
+			if json.Valid([]byte(variableRegexp.ReplaceAllString(body, "0"))) {
+				pass.Reportf(req, "JSON body without a Content-Type header")
+			}
+		}
+	},
+}
+
+// ResponseInPreScriptRule reports pre-request scripts referencing the response, which
+// is only available to post-request scripts.
+var ResponseInPreScriptRule = LintRule{
+	Name: "response-in-pre-script",
+	Doc:  "report pre-request scripts referencing the response",
+	Check: func(pass *LintPass) {
+		for _, req := range pass.Requests {
+			if responseRegexp.MatchString(req.PreRequestScript) {
+				pass.Reportf(req, "pre-request script references the response, which is only available to post-request scripts")
+			}
+		}
+	},
+}
+
+// requestTemplates returns the texts of the request in which variables are replaced,
+// the body includes the parts of multipart requests.
+func requestTemplates(req Request) []string {
+	texts := []string{req.URL, req.ResponseFile}
+	for _, header := range req.Headers {
+		texts = append(texts, header.Key, header.Value)
+	}
+	if req.BodyFile == "" || req.SubstituteBodyFile {
+		texts = append(texts, req.Body)
+	}
+	return texts
+}
+
+// scriptVariables adds the variables set by the script to defined.
+func scriptVariables(script string, defined map[string]bool) {
+	for _, match := range setEnvRegexp.FindAllStringSubmatch(script, -1) {
+		for _, name := range match[1:] {
+			if name != "" {
+				defined[name] = true
+			}
+		}
+	}
+}
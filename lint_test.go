@@ -0,0 +1,142 @@
+package rq
+
+import (
+	"testing"
+
+	"github.com/go-rq/rq/ast"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLint(t *testing.T) {
+	requests, err := ParseRequests(`### Get User
+GET {{host}}/users/{{id}}
+
+{"name": "Fred"}
+
+### Get User
+# @no-lint body-on-get
+< {% log(response.status) %}
+POST {{host}}/users
+Authorization: Bearer {{token}}
+Content-Type: application/json
+
+{"id": {{id}}}
+
+< {% setEnv('token', response.body.token) %}
+
+### Refresh
+# @no-lint
+GET {{host}}/refresh?token={{token}}&{{undefined}}
+
+{"a": 1}
+
+### Me
+@user = me
+GET {{host}}/{{user}}/{{token}}/{{$uuid}}?page={{page | default 1}}&id={{ id | urlencode }}
+X-Token: {{Refresh.response.body.$.token}} {{Me.response.headers.Location}}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("The default rules are checked", func(t *testing.T) {
+		diagnostics := Lint(requests, map[string]string{"host": "http://localhost"})
+		if diff := cmp.Diff([]Diagnostic{
+			{Line: 2, Request: "Get User", Rule: "undefined-variable", Message: "undefined variable {{id}}"},
+			{Line: 2, Request: "Get User", Rule: "body-on-get", Message: "GET request with a body"},
+			{Line: 2, Request: "Get User", Rule: "json-content-type", Message: "JSON body without a Content-Type header"},
+			{Line: 9, Request: "Get User", Rule: "undefined-variable", Message: "undefined variable {{token}}"},
+			{Line: 9, Request: "Get User", Rule: "undefined-variable", Message: "undefined variable {{id}}"},
+			{Line: 9, Request: "Get User", Rule: "duplicate-name", Message: `duplicate request name "Get User", first used on line 2`},
+			{Line: 9, Request: "Get User", Rule: "response-in-pre-script", Message: "pre-request script references the response, which is only available to post-request scripts"},
+			{Line: 25, Request: "Me", Rule: "undefined-variable", Message: "undefined variable {{id}}"},
+			{Line: 25, Request: "Me", Rule: "undefined-variable", Message: `{{Me.response.headers.Location}} references request "Me", which does not run before`},
+		}, diagnostics); diff != "" {
+			t.Errorf("diagnostics mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Variables are located in the source", func(t *testing.T) {
+		src := `### Get User
+GET {{host}}/users/{{id}}
+X-Token: {{token}}
+
+{"id": {{id}}, "next": {{Next.response.body.$.id}}}
+
+### Next
+GET {{host}}/users
+`
+		requests, err := ParseRequests(src, WithFileName("api.http"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		diagnostics := Lint(requests, map[string]string{"host": "http://localhost"},
+			WithLintSource(ast.ParseFile([]byte(src))), WithLintRules(UndefinedVariableRule))
+		if diff := cmp.Diff([]Diagnostic{
+			{File: "api.http", Line: 2, Column: 20, EndColumn: 26, Request: "Get User", Rule: "undefined-variable", Message: "undefined variable {{id}}"},
+			{File: "api.http", Line: 3, Column: 10, EndColumn: 19, Request: "Get User", Rule: "undefined-variable", Message: "undefined variable {{token}}"},
+			{File: "api.http", Line: 5, Column: 24, EndColumn: 51, Request: "Get User", Rule: "undefined-variable", Message: `{{Next.response.body.$.id}} references request "Next", which does not run before`},
+		}, diagnostics); diff != "" {
+			t.Errorf("diagnostics mismatch (-want +got):\n%s", diff)
+		}
+		if got := diagnostics[0].String(); got != "api.http:2:20: undefined variable {{id}} (undefined-variable)" {
+			t.Errorf("unexpected string %q", got)
+		}
+	})
+
+	t.Run("Variables set with setSecret are defined", func(t *testing.T) {
+		requests, err := ParseRequests(`### Login
+POST {{host}}/login
+
+< {% setSecret('token', response.body.token) %}
+
+### Me
+GET {{host}}/me
+Authorization: Bearer {{token}}
+`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]Diagnostic(nil), Lint(requests, map[string]string{"host": "http://localhost"})); diff != "" {
+			t.Errorf("diagnostics mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Rules can be disabled", func(t *testing.T) {
+		diagnostics := Lint(requests, map[string]string{"host": "http://localhost", "id": "1"},
+			WithoutLintRules("undefined-variable", "response-in-pre-script", "duplicate-name"))
+		if diff := cmp.Diff([]string{"body-on-get", "json-content-type"}, rules(diagnostics)); diff != "" {
+			t.Errorf("rules mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Custom rules can be checked", func(t *testing.T) {
+		rule := LintRule{
+			Name: "named",
+			Check: func(pass *LintPass) {
+				for _, req := range pass.Requests {
+					if req.Name == "" {
+						pass.Reportf(req, "unnamed request")
+					}
+				}
+			},
+		}
+		diagnostics := Lint([]Request{{Method: "GET", URL: "/", File: "api.http", Line: 3}}, nil, WithLintRules(rule))
+		if diff := cmp.Diff([]Diagnostic{
+			{File: "api.http", Line: 3, Rule: "named", Message: "unnamed request"},
+		}, diagnostics); diff != "" {
+			t.Errorf("diagnostics mismatch (-want +got):\n%s", diff)
+		}
+		if got := diagnostics[0].String(); got != "api.http:3: unnamed request (named)" {
+			t.Errorf("unexpected string %q", got)
+		}
+	})
+}
+
+func rules(diagnostics []Diagnostic) []string {
+	var names []string
+	for _, diagnostic := range diagnostics {
+		names = append(names, diagnostic.Rule)
+	}
+	return names
+}
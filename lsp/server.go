@@ -0,0 +1,608 @@
+// Package lsp implements a Language Server Protocol server for .http files on top
+// of the rq parser, so that editors report exactly what rq accepts.
+//
+// The server provides diagnostics for parse errors and lint issues, completion of
+// {{variables}} from environment files and file variables, hover showing the
+// resolved value of variables, go-to-definition for script and body file includes,
+// and a code lens running the request under the cursor with Request.Do.
+package lsp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/textproto"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-rq/rq"
+	"github.com/go-rq/rq/ast"
+)
+
+// RunRequestCommand is the command of the code lens running a request, its
+// arguments are the URI of the document and the line of the request line.
+const RunRequestCommand = "rq.runRequest"
+
+// variableRegexp matches the {{variables}} of a line.
+var variableRegexp = regexp.MustCompile(`{{\s*([^{}]*?)\s*}}`)
+
+// server holds the state of a session with a client.
+type server struct {
+	ctx context.Context
+	out io.Writer
+	// mu guards writes to out
+	mu sync.Mutex
+	// documents holds the text of the open documents by URI
+	documents map[string]string
+	// environment is the name of the environment used to resolve variables
+	environment string
+	// environments holds the environments of the directories of the documents
+	environments map[string]*dirEnvironment
+	shutdown     bool
+}
+
+// dirEnvironment is the environment of the documents of a directory: files holds the
+// variables of the environment files and runs, a scope of files, the variables set by
+// the scripts of the requests run from the editor, which are shared by later runs.
+type dirEnvironment struct {
+	files, runs *rq.Environment
+	// variables and secrets are the ones last loaded in files
+	variables map[string]string
+	secrets   map[string]bool
+}
+
+// Serve runs a server reading the messages of the client from in and writing
+// responses and notifications to out, as done over stdio. It returns when the
+// client sends the exit notification or in is closed.
+//
+// Requests run from code lenses use the context, which can provide the request
+// runner and the initial environment. They run in the background and their results
+// are logged with window/logMessage. The variables set by their scripts are shared by
+// the requests of the same directory run afterwards.
+func Serve(ctx context.Context, in io.Reader, out io.Writer) error {
+	if rq.GetHistory(ctx) == nil {
+		// the requests run from the editor reference the responses of the ones run before
+		// them, the responses referenced by the open documents are recorded
+		ctx = rq.WithHistory(ctx, rq.NewHistoryFor(nil))
+	}
+	// the environments of the directories are scopes of the environment of the context,
+	// which must be the same for all of them
+	ctx = rq.WithEnvironmentScope(ctx, rq.GetEnvironment(ctx))
+	s := &server{ctx: ctx, out: out, documents: map[string]string{}, environments: map[string]*dirEnvironment{}}
+	reader := bufio.NewReader(in)
+	for {
+		msg, err := readMessage(reader)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			var rpcErr *responseError
+			if errors.As(err, &rpcErr) {
+				s.reply(nil, nil, rpcErr)
+				continue
+			}
+			return err
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		result, err := s.handle(msg)
+		if msg.ID == nil {
+			// notifications have no response
+			continue
+		}
+		var rpcErr *responseError
+		if err != nil && !errors.As(err, &rpcErr) {
+			rpcErr = &responseError{Code: codeRequestFailed, Message: err.Error()}
+		}
+		s.reply(msg.ID, result, rpcErr)
+	}
+}
+
+// readMessage reads a message framed by a Content-Length header.
+func readMessage(reader *bufio.Reader) (*message, error) {
+	header, err := textproto.NewReader(reader).ReadMIMEHeader()
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length %q", header.Get("Content-Length"))
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, err
+	}
+	msg := &message{}
+	if err := json.Unmarshal(body, msg); err != nil {
+		return nil, &responseError{Code: codeParseError, Message: err.Error()}
+	}
+	return msg, nil
+}
+
+// write writes a message framed by a Content-Length header.
+func (s *server) write(msg message) {
+	msg.JSONRPC = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (s *server) reply(id *json.RawMessage, result any, err *responseError) {
+	if id == nil {
+		null := json.RawMessage("null")
+		id = &null
+	}
+	if err == nil && result == nil {
+		// the result is required in successful responses
+		result = json.RawMessage("null")
+	}
+	s.write(message{ID: id, Result: result, Error: err})
+}
+
+func (s *server) notify(method string, params any) {
+	b, err := json.Marshal(params)
+	if err != nil {
+		return
+	}
+	s.write(message{Method: method, Params: b})
+}
+
+func (s *server) handle(msg *message) (any, error) {
+	if s.shutdown && msg.Method != "exit" {
+		return nil, &responseError{Code: codeInvalidParams, Message: "server is shut down"}
+	}
+	switch msg.Method {
+	case "initialize":
+		var params initializeParams
+		if err := unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		s.environment = params.InitializationOptions.Environment
+		return map[string]any{
+			"capabilities": map[string]any{
+				// full document sync
+				"textDocumentSync":       1,
+				"completionProvider":     map[string]any{"triggerCharacters": []string{"{"}},
+				"hoverProvider":          true,
+				"definitionProvider":     true,
+				"codeLensProvider":       map[string]any{},
+				"executeCommandProvider": map[string]any{"commands": []string{RunRequestCommand}},
+			},
+			"serverInfo": map[string]any{"name": "rq"},
+		}, nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		s.documents[params.TextDocument.URI] = params.TextDocument.Text
+		s.publishDiagnostics(params.TextDocument.URI)
+		return nil, nil
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		if n := len(params.ContentChanges); n > 0 {
+			s.documents[params.TextDocument.URI] = params.ContentChanges[n-1].Text
+		}
+		s.publishDiagnostics(params.TextDocument.URI)
+		return nil, nil
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err := unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		delete(s.documents, params.TextDocument.URI)
+		s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: params.TextDocument.URI, Diagnostics: []diagnostic{}})
+		return nil, nil
+	case "textDocument/completion":
+		var params textDocumentPositionParams
+		if err := unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.completion(params), nil
+	case "textDocument/hover":
+		var params textDocumentPositionParams
+		if err := unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.hover(params), nil
+	case "textDocument/definition":
+		var params textDocumentPositionParams
+		if err := unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.definition(params), nil
+	case "textDocument/codeLens":
+		var params codeLensParams
+		if err := unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.codeLenses(params.TextDocument.URI), nil
+	case "workspace/executeCommand":
+		var params executeCommandParams
+		if err := unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.executeCommand(params)
+	}
+	if msg.ID == nil || strings.HasPrefix(msg.Method, "$/") {
+		return nil, nil
+	}
+	return nil, &responseError{Code: codeMethodNotFound, Message: fmt.Sprintf("method not found: %s", msg.Method)}
+}
+
+func unmarshal(params json.RawMessage, v any) error {
+	if len(params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return &responseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+// document is an open document parsed on demand.
+type document struct {
+	uri      string
+	path     string
+	text     string
+	lines    []string
+	file     *ast.File
+	requests []rq.Request
+	err      error
+}
+
+func (s *server) document(uri string) (*document, bool) {
+	text, ok := s.documents[uri]
+	if !ok {
+		return nil, false
+	}
+	doc := &document{uri: uri, path: uriToPath(uri), text: text, file: ast.ParseFile([]byte(text))}
+	doc.lines = strings.Split(text, "\n")
+	doc.requests, doc.err = rq.ParseRequests(text, rq.WithFileName(doc.path))
+	return doc, true
+}
+
+// line returns the text of a 0-based line without its line ending.
+func (d *document) line(n int) string {
+	if n < 0 || n >= len(d.lines) {
+		return ""
+	}
+	return strings.TrimSuffix(d.lines[n], "\r")
+}
+
+// offset returns the byte offset of an LSP position.
+func (d *document) offset(pos position) ast.Pos {
+	offset := 0
+	for i := 0; i < pos.Line && i < len(d.lines); i++ {
+		offset += len(d.lines[i]) + 1
+	}
+	return ast.Pos(offset + byteOffset(d.line(pos.Line), pos.Character))
+}
+
+// position returns the LSP position of a byte offset.
+func (d *document) position(pos ast.Pos) position {
+	p := d.file.Position(pos)
+	return position{Line: p.Line - 1, Character: utf16Column(d.line(p.Line-1), p.Column-1)}
+}
+
+// lineRange returns the range of a whole 0-based line.
+func (d *document) lineRange(n int) textRange {
+	return textRange{
+		Start: position{Line: n},
+		End:   position{Line: n, Character: utf16Column(d.line(n), len(d.line(n)))},
+	}
+}
+
+// block returns the block of the file containing the offset.
+func (d *document) block(pos ast.Pos) *ast.Request {
+	for _, block := range d.file.Requests {
+		if pos >= block.Pos() && (pos < block.End() || block == d.file.Requests[len(d.file.Requests)-1]) {
+			return block
+		}
+	}
+	return nil
+}
+
+// request returns the request of a block, it is false when the block does not define
+// a valid request.
+func (d *document) request(block *ast.Request) (rq.Request, bool) {
+	if block == nil || block.Line == nil {
+		return rq.Request{}, false
+	}
+	line := d.file.Position(block.Line.Pos()).Line
+	for _, req := range d.requests {
+		if req.Line == line {
+			return req, true
+		}
+	}
+	return rq.Request{}, false
+}
+
+// environmentOf returns the scope of the environment of the context for the directory
+// of the document, holding the variables set by the requests run from the editor and
+// the variables of the selected environment of the environment files of the directory
+// that the context does not set. When no environment is selected the variables of all
+// environments are merged. The environment files are read again on each call.
+func (s *server) environmentOf(doc *document) *rq.Environment {
+	global := rq.GetEnvironment(s.ctx)
+	dir := filepath.Dir(doc.path)
+	env, ok := s.environments[dir]
+	if !ok {
+		files := global.Scope()
+		env = &dirEnvironment{files: files, runs: files.Scope()}
+		s.environments[dir] = env
+	}
+	// invalid environment files are ignored, the variables they define are then reported as undefined
+	environments, _ := rq.LoadEnvironments(dir)
+	names := environments.Names()
+	if s.environment != "" {
+		names = []string{s.environment}
+	}
+	variables, secrets := map[string]string{}, map[string]bool{}
+	for _, name := range names {
+		values, _ := environments.Get(name)
+		for _, key := range environments.SecretKeys(name) {
+			if _, ok := variables[key]; !ok {
+				secrets[key] = true
+			}
+		}
+		for key, value := range values {
+			if _, ok := global.Get(key); ok {
+				continue
+			}
+			if _, ok := variables[key]; !ok {
+				variables[key] = value
+			}
+		}
+	}
+	if maps.Equal(variables, env.variables) && maps.Equal(secrets, env.secrets) {
+		// the variables are only replaced when the files change, as requests may be running
+		return env.runs
+	}
+	env.files.Reset()
+	for key, value := range variables {
+		if secrets[key] {
+			env.files.SetSecret(key, value)
+		} else {
+			env.files.Set(key, value)
+		}
+	}
+	env.variables, env.secrets = variables, secrets
+	return env.runs
+}
+
+// publishDiagnostics reports the parse errors and lint issues of a document.
+func (s *server) publishDiagnostics(uri string) {
+	doc, ok := s.document(uri)
+	if !ok {
+		return
+	}
+	diagnostics := []diagnostic{}
+	var parseErrs rq.ParseErrors
+	var parseErr *rq.ParseError
+	switch {
+	case errors.As(doc.err, &parseErrs):
+	case errors.As(doc.err, &parseErr):
+		parseErrs = rq.ParseErrors{parseErr}
+	}
+	for _, err := range parseErrs {
+		r := doc.lineRange(err.Line - 1)
+		r.Start.Character = utf16Column(doc.line(err.Line-1), err.Column-1)
+		diagnostics = append(diagnostics, diagnostic{
+			Range:    r,
+			Severity: severityError,
+			Source:   "rq",
+			Message:  err.Err.Error(),
+		})
+	}
+	for _, issue := range rq.Lint(doc.requests, s.environmentOf(doc).Strings(), rq.WithLintSource(doc.file)) {
+		r := doc.lineRange(issue.Line - 1)
+		if issue.Column > 0 {
+			r.Start.Character = utf16Column(doc.line(issue.Line-1), issue.Column-1)
+		}
+		if issue.EndColumn > 0 {
+			r.End.Character = utf16Column(doc.line(issue.Line-1), issue.EndColumn-1)
+		}
+		diagnostics = append(diagnostics, diagnostic{
+			Range:    r,
+			Severity: severityWarning,
+			Code:     issue.Rule,
+			Source:   "rq vet",
+			Message:  issue.Message,
+		})
+	}
+	s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: uri, Diagnostics: diagnostics})
+}
+
+// completion completes the {{variable}} at the position with the variables of the
+// environment and the file variables of the document.
+func (s *server) completion(params textDocumentPositionParams) []completionItem {
+	items := []completionItem{}
+	doc, ok := s.document(params.TextDocument.URI)
+	if !ok {
+		return items
+	}
+	before := doc.line(params.Position.Line)[:byteOffset(doc.line(params.Position.Line), params.Position.Character)]
+	open := strings.LastIndex(before, "{{")
+	if open < 0 || strings.Contains(before[open:], "}}") {
+		return items
+	}
+	variables := s.environmentOf(doc).Strings()
+	for _, block := range doc.file.Requests {
+		for _, node := range block.Nodes {
+			if variable, ok := node.(*ast.Variable); ok {
+				if _, ok := variables[variable.Name.Value]; !ok {
+					variables[variable.Name.Value] = variable.Value.Value
+				}
+			}
+		}
+	}
+	for name, value := range variables {
+		items = append(items, completionItem{Label: name, Kind: completionItemKindVariable, Detail: value})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+	return items
+}
+
+// hover shows the resolved value of the {{variable}} at the position.
+func (s *server) hover(params textDocumentPositionParams) *hover {
+	doc, ok := s.document(params.TextDocument.URI)
+	if !ok {
+		return nil
+	}
+	line := doc.line(params.Position.Line)
+	offset := byteOffset(line, params.Position.Character)
+	for _, match := range variableRegexp.FindAllStringSubmatchIndex(line, -1) {
+		if offset < match[0] || offset >= match[1] {
+			continue
+		}
+		name := line[match[2]:match[3]]
+		req, _ := doc.request(doc.block(doc.offset(params.Position)))
+		ctx := rq.WithEnvironmentScope(s.ctx, s.environmentOf(doc))
+		value, ok := req.Variable(ctx, name)
+		content := fmt.Sprintf("`%s` is undefined", name)
+		if ok {
+			content = fmt.Sprintf("`%s` = `%s`", name, rq.Redact(ctx, value))
+		}
+		return &hover{
+			Contents: markupContent{Kind: "markdown", Value: content},
+			Range: textRange{
+				Start: position{Line: params.Position.Line, Character: utf16Column(line, match[0])},
+				End:   position{Line: params.Position.Line, Character: utf16Column(line, match[1])},
+			},
+		}
+	}
+	return nil
+}
+
+// definition returns the location of the script or body file included on the line
+// of the position.
+func (s *server) definition(params textDocumentPositionParams) []location {
+	locations := []location{}
+	doc, ok := s.document(params.TextDocument.URI)
+	if !ok {
+		return locations
+	}
+	pos := doc.offset(params.Position)
+	block := doc.block(pos)
+	if block == nil {
+		return locations
+	}
+	for _, node := range block.Nodes {
+		if pos < node.Pos() || pos >= node.End() {
+			continue
+		}
+		var path string
+		switch node := node.(type) {
+		case *ast.Script:
+			path = node.Path.Value
+		case *ast.BodyFile:
+			path = node.Path.Value
+		}
+		if path == "" {
+			return locations
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(doc.path), path)
+		}
+		return append(locations, location{URI: pathToURI(path)})
+	}
+	return locations
+}
+
+// codeLenses returns a code lens running each request of the document.
+func (s *server) codeLenses(uri string) []codeLens {
+	lenses := []codeLens{}
+	doc, ok := s.document(uri)
+	if !ok {
+		return lenses
+	}
+	for _, req := range doc.requests {
+		lenses = append(lenses, codeLens{
+			Range: doc.lineRange(req.Line - 1),
+			Command: command{
+				Title:     "Run request",
+				Command:   RunRequestCommand,
+				Arguments: []any{uri, req.Line},
+			},
+		})
+	}
+	return lenses
+}
+
+// executeCommand starts running the request of a code lens, its response is logged to
+// the client once received.
+func (s *server) executeCommand(params executeCommandParams) (any, error) {
+	if params.Command != RunRequestCommand {
+		return nil, &responseError{Code: codeInvalidParams, Message: fmt.Sprintf("unknown command %q", params.Command)}
+	}
+	var uri string
+	var line int
+	if len(params.Arguments) != 2 || json.Unmarshal(params.Arguments[0], &uri) != nil || json.Unmarshal(params.Arguments[1], &line) != nil {
+		return nil, &responseError{Code: codeInvalidParams, Message: "expected the document URI and the line of the request"}
+	}
+	doc, ok := s.document(uri)
+	if !ok {
+		return nil, &responseError{Code: codeInvalidParams, Message: fmt.Sprintf("unknown document %s", uri)}
+	}
+	if history := rq.GetHistory(s.ctx); history != nil {
+		for uri := range s.documents {
+			if doc, ok := s.document(uri); ok {
+				history.Reference(doc.requests...)
+			}
+		}
+	}
+	for _, req := range doc.requests {
+		if req.Line != line {
+			continue
+		}
+		// the request runs in the background so that a slow request does not block
+		// the other messages, its result is logged
+		go s.run(rq.WithEnvironmentScope(s.ctx, s.environmentOf(doc)), req)
+		return nil, nil
+	}
+	return nil, &responseError{Code: codeInvalidParams, Message: fmt.Sprintf("no request on line %d", line)}
+}
+
+// run runs a request and logs its response and the results of its assertions.
+func (s *server) run(ctx context.Context, req rq.Request) {
+	resp, err := req.Do(ctx)
+	if err != nil {
+		s.notify("window/logMessage", logMessageParams{Type: messageTypeError, Message: fmt.Sprintf("%s\n\n%s", req.DisplayName(), err)})
+		return
+	}
+	defer resp.Body.Close()
+	result := resp.String()
+	for _, assertion := range append(req.PreRequestAssertions, resp.PostRequestAssertions...) {
+		status := "passed"
+		if !assertion.Success {
+			status = "failed"
+		}
+		result += fmt.Sprintf("\n%s: %s", status, assertion.Message)
+	}
+	s.notify("window/logMessage", logMessageParams{Type: messageTypeInfo, Message: fmt.Sprintf("%s\n\n%s", req.DisplayName(), result)})
+}
@@ -0,0 +1,313 @@
+package lsp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-rq/rq"
+	"github.com/google/go-cmp/cmp"
+)
+
+// client drives a server over pipes.
+type client struct {
+	t     *testing.T
+	in    io.WriteCloser
+	out   *bufio.Reader
+	id    int
+	notes []message
+	done  chan error
+}
+
+func newClient(t *testing.T, ctx context.Context) *client {
+	inReader, inWriter := io.Pipe()
+	outReader, outWriter := io.Pipe()
+	c := &client{t: t, in: inWriter, out: bufio.NewReader(outReader), done: make(chan error, 1)}
+	go func() {
+		c.done <- Serve(ctx, inReader, outWriter)
+		outWriter.Close()
+	}()
+	t.Cleanup(func() {
+		c.notify("exit", nil)
+		if err := <-c.done; err != nil {
+			t.Error(err)
+		}
+	})
+	return c
+}
+
+func (c *client) send(msg message) {
+	msg.JSONRPC = "2.0"
+	b, err := json.Marshal(msg)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := fmt.Fprintf(c.in, "Content-Length: %d\r\n\r\n%s", len(b), b); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *client) notify(method string, params any) {
+	b, _ := json.Marshal(params)
+	c.send(message{Method: method, Params: b})
+}
+
+// call sends a request and decodes the result of its response in result, the
+// notifications received meanwhile are collected.
+func (c *client) call(method string, params, result any) *responseError {
+	c.t.Helper()
+	c.id++
+	id := json.RawMessage(fmt.Sprint(c.id))
+	b, _ := json.Marshal(params)
+	c.send(message{ID: &id, Method: method, Params: b})
+	for {
+		msg := c.read()
+		if msg.ID == nil {
+			c.notes = append(c.notes, msg)
+			continue
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if result != nil {
+			b, _ := json.Marshal(msg.Result)
+			if err := json.Unmarshal(b, result); err != nil {
+				c.t.Fatal(err)
+			}
+		}
+		return nil
+	}
+}
+
+// notification returns the next notification of the method.
+func (c *client) notification(method string, params any) {
+	c.t.Helper()
+	for {
+		var msg message
+		if len(c.notes) > 0 {
+			msg, c.notes = c.notes[0], c.notes[1:]
+		} else {
+			msg = c.read()
+		}
+		if msg.Method != method {
+			continue
+		}
+		if err := json.Unmarshal(msg.Params, params); err != nil {
+			c.t.Fatal(err)
+		}
+		return
+	}
+}
+
+func (c *client) read() message {
+	c.t.Helper()
+	msg, err := readMessage(c.out)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return *msg
+}
+
+func TestServe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", "")
+		fmt.Fprintf(w, "%s %s", r.Method, r.URL.Path)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(rq.EnvironmentFile, fmt.Sprintf(`{"dev": {"host": %q}, "prod": {"host": "https://example.com"}}`, server.URL))
+	write(rq.PrivateEnvironmentFile, `{"dev": {"token": "secret"}}`)
+	write("check.js", "assert(response.statusCode === 200, 'ok')")
+	uri := pathToURI(filepath.Join(dir, "api.http"))
+	text := `@version = v1
+
+### Get User
+GET {{host}}/{{version}}/users
+Authorization: Bearer {{token}}
+
+< check.js
+
+### Broken
+not a request
+`
+
+	c := newClient(t, context.Background())
+	var initialized struct {
+		Capabilities map[string]any `json:"capabilities"`
+	}
+	if err := c.call("initialize", map[string]any{"initializationOptions": map[string]any{"environment": "dev"}}, &initialized); err != nil {
+		t.Fatal(err)
+	}
+	if initialized.Capabilities["hoverProvider"] != true {
+		t.Errorf("unexpected capabilities %v", initialized.Capabilities)
+	}
+	c.notify("initialized", map[string]any{})
+	c.notify("textDocument/didOpen", map[string]any{"textDocument": map[string]any{"uri": uri, "languageId": "http", "text": text}})
+
+	t.Run("Parse errors and lint issues are published", func(t *testing.T) {
+		var params publishDiagnosticsParams
+		c.notification("textDocument/publishDiagnostics", &params)
+		if diff := cmp.Diff(publishDiagnosticsParams{
+			URI: uri,
+			Diagnostics: []diagnostic{{
+				Range:    textRange{Start: position{Line: 9}, End: position{Line: 9, Character: 13}},
+				Severity: severityError,
+				Source:   "rq",
+				Message:  "invalid request: request does not include method or URL",
+			}},
+		}, params); diff != "" {
+			t.Errorf("diagnostics mismatch (-want +got):\n%s", diff)
+		}
+
+		c.notify("textDocument/didChange", map[string]any{
+			"textDocument":   map[string]any{"uri": uri, "version": 2},
+			"contentChanges": []map[string]any{{"text": "GET {{host}}/{{undefined}}\n\n{}\n"}},
+		})
+		c.notification("textDocument/publishDiagnostics", &params)
+		var codes []string
+		for _, d := range params.Diagnostics {
+			codes = append(codes, d.Code)
+		}
+		if diff := cmp.Diff([]string{"body-on-get", "json-content-type", "undefined-variable"}, codes); diff != "" {
+			t.Errorf("diagnostics mismatch (-want +got):\n%s", diff)
+		}
+		if diff := cmp.Diff(textRange{Start: position{Line: 0, Character: 13}, End: position{Line: 0, Character: 26}}, params.Diagnostics[2].Range); diff != "" {
+			t.Errorf("range mismatch (-want +got):\n%s", diff)
+		}
+		c.notify("textDocument/didChange", map[string]any{
+			"textDocument":   map[string]any{"uri": uri, "version": 3},
+			"contentChanges": []map[string]any{{"text": text}},
+		})
+		c.notification("textDocument/publishDiagnostics", &params)
+	})
+
+	t.Run("Variables are completed", func(t *testing.T) {
+		var items []completionItem
+		if err := c.call("textDocument/completion", textDocumentPositionParams{
+			TextDocument: textDocumentIdentifier{URI: uri},
+			Position:     position{Line: 3, Character: 6},
+		}, &items); err != nil {
+			t.Fatal(err)
+		}
+		var labels []string
+		for _, item := range items {
+			labels = append(labels, item.Label)
+		}
+		if diff := cmp.Diff([]string{"host", "token", "version"}, labels); diff != "" {
+			t.Errorf("completion mismatch (-want +got):\n%s", diff)
+		}
+		if err := c.call("textDocument/completion", textDocumentPositionParams{
+			TextDocument: textDocumentIdentifier{URI: uri},
+			Position:     position{Line: 3, Character: 3},
+		}, &items); err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != 0 {
+			t.Errorf("expected no completion outside of a variable, got %v", items)
+		}
+	})
+
+	t.Run("Hovering a variable shows its value", func(t *testing.T) {
+		for _, test := range []struct {
+			position position
+			expected string
+		}{
+			{position{Line: 3, Character: 7}, "`host` = `" + server.URL + "`"},
+			{position{Line: 3, Character: 14}, "`version` = `v1`"},
+			{position{Line: 4, Character: 25}, "`token` = `****`"},
+		} {
+			var result hover
+			if err := c.call("textDocument/hover", textDocumentPositionParams{
+				TextDocument: textDocumentIdentifier{URI: uri},
+				Position:     test.position,
+			}, &result); err != nil {
+				t.Fatal(err)
+			}
+			if result.Contents.Value != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, result.Contents.Value)
+			}
+		}
+	})
+
+	t.Run("Script includes can be followed", func(t *testing.T) {
+		var locations []location
+		if err := c.call("textDocument/definition", textDocumentPositionParams{
+			TextDocument: textDocumentIdentifier{URI: uri},
+			Position:     position{Line: 6, Character: 4},
+		}, &locations); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]location{{URI: pathToURI(filepath.Join(dir, "check.js"))}}, locations); diff != "" {
+			t.Errorf("locations mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Requests are run from code lenses", func(t *testing.T) {
+		var lenses []codeLens
+		if err := c.call("textDocument/codeLens", codeLensParams{TextDocument: textDocumentIdentifier{URI: uri}}, &lenses); err != nil {
+			t.Fatal(err)
+		}
+		if len(lenses) != 1 || lenses[0].Range.Start.Line != 3 || lenses[0].Command.Command != RunRequestCommand {
+			t.Fatalf("unexpected code lenses %+v", lenses)
+		}
+		if err := c.call("workspace/executeCommand", map[string]any{
+			"command":   lenses[0].Command.Command,
+			"arguments": lenses[0].Command.Arguments,
+		}, nil); err != nil {
+			t.Fatal(err)
+		}
+		var params logMessageParams
+		c.notification("window/logMessage", &params)
+		if params.Type != messageTypeInfo || !strings.HasSuffix(params.Message, "GET /v1/users\npassed: ok") {
+			t.Errorf("unexpected result %+v", params)
+		}
+	})
+
+	t.Run("Variables set by runs are shared with the next runs", func(t *testing.T) {
+		sessions := pathToURI(filepath.Join(dir, "sessions.http"))
+		c.notify("textDocument/didOpen", map[string]any{"textDocument": map[string]any{"uri": sessions, "languageId": "http", "text": `### Login
+POST {{host}}/login
+
+< {% setEnv('session', 'abc') %}
+
+### Session
+GET {{host}}/sessions/{{session}}
+`}})
+		var diagnostics publishDiagnosticsParams
+		c.notification("textDocument/publishDiagnostics", &diagnostics)
+		for _, line := range []int{2, 7} {
+			if err := c.call("workspace/executeCommand", map[string]any{
+				"command":   RunRequestCommand,
+				"arguments": []any{sessions, line},
+			}, nil); err != nil {
+				t.Fatal(err)
+			}
+			var params logMessageParams
+			c.notification("window/logMessage", &params)
+			if line == 7 && !strings.HasSuffix(params.Message, "GET /sessions/abc") {
+				t.Errorf("unexpected result %+v", params)
+			}
+		}
+	})
+
+	t.Run("Unknown methods are reported", func(t *testing.T) {
+		err := c.call("textDocument/unknown", map[string]any{}, nil)
+		if err == nil || err.Code != codeMethodNotFound {
+			t.Errorf("expected a method not found error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,153 @@
+package rq
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"regexp"
+	"strings"
+
+	"github.com/go-rq/rq/ast"
+)
+
+// partFileRegexp matches the file include of a part such as `< ./document.pdf`.
+var partFileRegexp = regexp.MustCompile(`^<\s+(.+)$`)
+
+// Part is a part of a multipart/form-data body.
+type Part struct {
+	// The headers of the part
+	// Example: Content-Disposition: form-data; name="file"; filename="document.pdf"
+	Headers Headers
+
+	// Body is the content of the part, or the content of the file when the part is a file part.
+	Body string
+
+	// File is the path of the file included with `< ./document.pdf`, relative to the .http file.
+	File string
+}
+
+// Name returns the form field name given in the Content-Disposition header of the part.
+func (p Part) Name() string {
+	return p.dispositionParams()["name"]
+}
+
+// FileName returns the file name given in the Content-Disposition header of the part.
+func (p Part) FileName() string {
+	return p.dispositionParams()["filename"]
+}
+
+func (p Part) dispositionParams() map[string]string {
+	_, params, err := mime.ParseMediaType(p.Headers.Get("Content-Disposition"))
+	if err != nil {
+		return map[string]string{}
+	}
+	return params
+}
+
+// multipartBoundary returns the boundary of a multipart/form-data Content-Type header.
+func multipartBoundary(headers Headers) (string, bool) {
+	mediaType, params, err := mime.ParseMediaType(headers.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		return "", false
+	}
+	return params["boundary"], true
+}
+
+// parseParts splits the body of a multipart/form-data request into its parts and loads
+// the content of file parts. It returns false when a part is invalid, the error is then
+// recorded by the parser.
+func (p *parser) parseParts(req *Request, body *ast.Body) bool {
+	boundary, ok := multipartBoundary(req.Headers)
+	if !ok || req.BodyFile != "" {
+		return true
+	}
+	var (
+		parts   []Part
+		current *Part
+		content []string
+		// inHeaders is set while reading the headers of the current part
+		inHeaders bool
+	)
+	appendPart := func() {
+		if current == nil {
+			return
+		}
+		if current.File == "" {
+			current.Body = strings.Join(content, "\n")
+		}
+		parts = append(parts, *current)
+	}
+	pos := body.Pos()
+	for _, raw := range strings.SplitAfter(body.Raw, "\n") {
+		linePos := pos
+		pos += ast.Pos(len(raw))
+		line := strings.TrimSuffix(strings.TrimSuffix(raw, "\n"), "\r")
+		trimmed := strings.TrimSpace(line)
+		if raw == "" {
+			continue
+		}
+		if trimmed == "--"+boundary || trimmed == "--"+boundary+"--" {
+			appendPart()
+			current, content, inHeaders = nil, nil, false
+			if trimmed == "--"+boundary {
+				current, inHeaders = &Part{}, true
+			}
+			continue
+		}
+		if current == nil {
+			// preamble and epilogue
+			continue
+		}
+		if inHeaders {
+			if trimmed == "" {
+				inHeaders = false
+				continue
+			}
+			key, value, ok := strings.Cut(line, ":")
+			if !ok || strings.TrimSpace(key) == "" {
+				p.errorAt(linePos, line, fmt.Errorf("%w: invalid part header", ErrInvalidRequest))
+				return false
+			}
+			current.Headers = append(current.Headers, Header{Key: strings.TrimSpace(key), Value: strings.TrimSpace(value)})
+			continue
+		}
+		if match := partFileRegexp.FindStringSubmatch(trimmed); match != nil && len(content) == 0 && current.File == "" {
+			name := strings.TrimSpace(match[1])
+			b, err := p.readFile(name)
+			if err != nil {
+				p.errorAt(linePos, line, fmt.Errorf("%w: reading part: %w", ErrInvalidRequest, err))
+				return false
+			}
+			current.File, current.Body = name, string(b)
+			continue
+		}
+		content = append(content, line)
+	}
+	appendPart()
+	req.Parts = parts
+	return true
+}
+
+// writeParts writes the parts as a multipart body delimited by boundary.
+func writeParts(w io.Writer, boundary string, parts []Part) error {
+	writer := multipart.NewWriter(w)
+	if err := writer.SetBoundary(boundary); err != nil {
+		return err
+	}
+	for _, part := range parts {
+		header := textproto.MIMEHeader{}
+		for _, h := range part.Headers {
+			header.Add(h.Key, h.Value)
+		}
+		pw, err := writer.CreatePart(header)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(pw, part.Body); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
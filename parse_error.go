@@ -0,0 +1,68 @@
+package rq
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseError describes an invalid line found while parsing a .http file, the wrapped
+// error then matches ErrInvalidRequest with errors.Is, or an error reading the file.
+type ParseError struct {
+	// File is the path of the parsed file, empty when the input was not read from a file.
+	File string
+	// Line is the 1-based line number of the offending line.
+	Line int
+	// Column is the 1-based column where the offending text starts.
+	Column int
+	// Text is the content of the offending line, empty for read errors.
+	Text string
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	file := e.File
+	if file == "" {
+		file = "<input>"
+	}
+	if e.Text == "" {
+		return fmt.Sprintf("%s:%d:%d: %s", file, e.Line, e.Column, e.Err)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s: %q", file, e.Line, e.Column, e.Err, strings.TrimSpace(e.Text))
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ParseErrors is returned when more than one request of a file is invalid. Each
+// error can be inspected with errors.As.
+type ParseErrors []*ParseError
+
+func (e ParseErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+func (e ParseErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// err returns nil when there are no errors, the single *ParseError when there is
+// one, and the list otherwise.
+func (e ParseErrors) err() error {
+	switch len(e) {
+	case 0:
+		return nil
+	case 1:
+		return e[0]
+	default:
+		return e
+	}
+}
@@ -0,0 +1,250 @@
+package rq
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/go-rq/rq/ast"
+)
+
+// ParseOption configures how requests are parsed.
+type ParseOption func(*parser)
+
+// WithFS resolves the files referenced by requests, such as script files, in fsys
+// instead of the OS filesystem. Paths are resolved relative to the root of fsys.
+func WithFS(fsys fs.FS) ParseOption {
+	return func(p *parser) {
+		p.fsys = fsys
+	}
+}
+
+// WithFileName sets the path of the parsed file, which is reported in errors and
+// against whose directory referenced files are resolved, ex., when parsing the
+// unsaved content of a file.
+func WithFileName(name string) ParseOption {
+	return func(p *parser) {
+		p.file = name
+		p.dir = filepath.Dir(name)
+	}
+}
+
+// ParseRequests parses the requests defined in the .http formatted input.
+//
+// If any request is invalid the returned error is a *ParseError, or a ParseErrors
+// when several requests are invalid, and the requests that could be parsed are
+// still returned.
+func ParseRequests(input string, options ...ParseOption) ([]Request, error) {
+	return parseRequests(strings.NewReader(input), options...)
+}
+
+// ParseFromFile parses the requests defined in the .http file at path. Script files
+// referenced by the requests are resolved relative to the directory of the file.
+func ParseFromFile(path string) ([]Request, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseRequests(f, WithFileName(path))
+}
+
+// ParseFS parses the requests defined in the .http file name of fsys. Script files
+// referenced by the requests are resolved in fsys relative to the directory of the
+// file, which allows parsing files embedded with go:embed.
+func ParseFS(fsys fs.FS, name string) ([]Request, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseRequests(f, WithFS(fsys), func(p *parser) {
+		p.file, p.dir = name, path.Dir(name)
+	})
+}
+
+// parser derives requests from the syntax tree of a .http file.
+type parser struct {
+	// file is the path of the parsed file, used when reporting errors.
+	file string
+	// dir is the directory relative script paths are resolved against.
+	dir string
+	// fsys is used to read referenced files when set, otherwise the OS filesystem is used.
+	fsys fs.FS
+	// position returns the position of an offset in the parsed file.
+	position func(ast.Pos) ast.Position
+	// variables are the file variables declared so far.
+	variables map[string]string
+	errs      ParseErrors
+}
+
+// parseRequests decodes all the requests of r, collecting the errors of invalid requests.
+func parseRequests(r io.Reader, options ...ParseOption) ([]Request, error) {
+	decoder := NewDecoder(r, options...)
+	var requests []Request
+	var errs ParseErrors
+	for {
+		req, err := decoder.Next()
+		if errors.Is(err, io.EOF) {
+			return requests, errs.err()
+		}
+		var parseErr *ParseError
+		if errors.As(err, &parseErr) && errors.Is(err, ErrInvalidRequest) {
+			errs = append(errs, parseErr)
+			continue
+		}
+		if err != nil {
+			return requests, errors.Join(errs.err(), err)
+		}
+		requests = append(requests, req)
+	}
+}
+
+// errorAt records a parse error for the line starting at pos. The column is the
+// 1-based position of the first non-blank character of the line.
+func (p *parser) errorAt(pos ast.Pos, text string, err error) {
+	text, _, _ = strings.Cut(text, "\n")
+	text = strings.TrimSuffix(text, "\r")
+	p.errs = append(p.errs, &ParseError{
+		File:   p.file,
+		Line:   p.position(pos).Line,
+		Column: len(text) - len(strings.TrimLeft(text, " \t")) + 1,
+		Text:   text,
+		Err:    err,
+	})
+}
+
+// request derives the request of a block of the file. It returns false when the
+// block does not define a request or when it is invalid, the error is then recorded.
+func (p *parser) request(block *ast.Request) (Request, bool) {
+	req := Request{File: p.file, FromFS: p.fsys != nil}
+	if block.Separator != nil {
+		req.Name = block.Separator.Name.Value
+	}
+	for _, node := range block.Nodes {
+		switch node := node.(type) {
+		case *ast.Bad:
+			p.errorAt(node.Pos(), node.Raw, fmt.Errorf("%w: %s", ErrInvalidRequest, node.Reason))
+			return req, false
+		case *ast.Annotation:
+			if err := parseAnnotation(&req, node.Key.Value, node.Value.Value); err != nil {
+				p.errorAt(node.Pos(), node.Raw, err)
+				return req, false
+			}
+		case *ast.Variable:
+			if p.variables == nil {
+				p.variables = map[string]string{}
+			}
+			p.variables[node.Name.Value] = node.Value.Value
+		case *ast.RequestLine:
+			req.Line = p.position(node.Pos()).Line
+			req.Method = node.Method.Value
+			if req.Method == "" {
+				req.Method = http.MethodGet
+			}
+			req.URL = node.URL.Value
+			req.Proto = node.Proto.Value
+		case *ast.URLContinuation:
+			req.URL += node.Text.Value
+			req.MultilineURL = true
+		case *ast.Header:
+			req.Headers = append(req.Headers, Header{Key: node.Key.Value, Value: node.Value.Value})
+		case *ast.Script:
+			script, err := p.script(node)
+			if err != nil {
+				p.errorAt(node.Pos(), node.Raw, err)
+				return req, false
+			}
+			if node.Post {
+				req.PostRequestScript = script
+			} else {
+				req.PreRequestScript = script
+			}
+		case *ast.Body:
+			req.Body = node.Value
+		case *ast.BodyFile:
+			b, err := p.readFile(node.Path.Value)
+			if err != nil {
+				p.errorAt(node.Pos(), node.Raw, fmt.Errorf("%w: reading body: %w", ErrInvalidRequest, err))
+				return req, false
+			}
+			req.Body = string(b)
+			req.BodyFile = node.Path.Value
+			req.SubstituteBodyFile = node.Substitute
+		case *ast.ResponseRedirect:
+			req.ResponseFile = node.Path.Value
+			req.OverwriteResponseFile = node.Overwrite
+		}
+	}
+	if block.Line == nil {
+		return req, false
+	}
+	if p.variables != nil {
+		req.Variables = maps.Clone(p.variables)
+	}
+	if block.Body != nil && !p.parseParts(&req, block.Body) {
+		return req, false
+	}
+	return req, true
+}
+
+// script returns the code of an inline script with the indentation of its lines and
+// the surrounding blank lines removed, or the content of a script file.
+func (p *parser) script(node *ast.Script) (string, error) {
+	if node.Path.Value != "" {
+		// the script is in a file at the path defined after the '<', read the script from the file
+		b, err := p.readFile(node.Path.Value)
+		if err != nil {
+			return "", fmt.Errorf("%w: reading script: %w", ErrInvalidRequest, err)
+		}
+		return string(b), nil
+	}
+	lines := strings.Split(node.Code.Value, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n")), nil
+}
+
+// readFile reads a file referenced by a request, relative paths are resolved
+// against the directory of the parsed file.
+func (p *parser) readFile(name string) ([]byte, error) {
+	if p.fsys == nil {
+		return os.ReadFile(resolvePath(p.dir, name))
+	}
+	name = path.Join(p.dir, name)
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	return fs.ReadFile(p.fsys, name)
+}
+
+// parseAnnotation adds an annotation to the request.
+func parseAnnotation(req *Request, key, value string) error {
+	switch key {
+	case AnnotationTag:
+		req.Tags = append(req.Tags, strings.FieldsFunc(value, func(r rune) bool {
+			return r == ',' || unicode.IsSpace(r)
+		})...)
+		return nil
+	case AnnotationName:
+		req.Name = value
+	case AnnotationTimeout:
+		if _, err := parseTimeout(value); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+		}
+	}
+	if req.Annotations == nil {
+		req.Annotations = map[string]string{}
+	}
+	req.Annotations[key] = value
+	return nil
+}
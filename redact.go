@@ -0,0 +1,98 @@
+package rq
+
+import (
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strings"
+)
+
+const (
+	// SecretPrefix marks the values of environment files and of the maps given to
+	// NewEnvironment as secret, ex., `"token": "secret:abc"` in an environment file.
+	SecretPrefix = "secret:"
+	// RedactedValue replaces the secret values in the text written by the library.
+	RedactedValue = "****"
+)
+
+// sensitiveHeaders are the headers whose values are masked in the text written by the
+// library, whether or not they hold secret variables.
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+// IsSecret reports whether the variable key of the scope or of its parents is secret.
+func (e *Environment) IsSecret(key string) bool {
+	for env := e; env != nil; env = env.parent {
+		env.mu.RLock()
+		secret := env.secrets[key]
+		env.mu.RUnlock()
+		if secret {
+			return true
+		}
+	}
+	return false
+}
+
+// Secrets returns the string form of the secret values of the scope and of its parents,
+// longest first.
+func (e *Environment) Secrets() []string {
+	var secrets []string
+	for key, value := range e.Strings() {
+		if value != "" && e.IsSecret(key) {
+			secrets = append(secrets, value)
+		}
+	}
+	sortSecrets(secrets)
+	return secrets
+}
+
+// Redact masks the secret values of the environment in text.
+func (e *Environment) Redact(text string) string {
+	return redact(text, e.Secrets())
+}
+
+// RedactHeader masks the value of the Authorization, Proxy-Authorization, Cookie and
+// Set-Cookie headers, keeping the authentication scheme, ex., `Bearer ****`. Values
+// holding {{templates}} are not masked.
+func RedactHeader(key, value string) string {
+	if !sensitiveHeaders[textproto.CanonicalMIMEHeaderKey(key)] || value == "" || strings.Contains(value, "{{") {
+		return value
+	}
+	if scheme, _, ok := strings.Cut(value, " "); ok && strings.HasSuffix(textproto.CanonicalMIMEHeaderKey(key), "Authorization") {
+		return scheme + " " + RedactedValue
+	}
+	return RedactedValue
+}
+
+// redactHeaders returns a copy of header with the values of the sensitive headers masked.
+func redactHeaders(header http.Header) http.Header {
+	redacted := header.Clone()
+	for key, values := range redacted {
+		for i, value := range values {
+			values[i] = RedactHeader(key, value)
+		}
+	}
+	return redacted
+}
+
+func redact(text string, secrets []string) string {
+	for _, secret := range secrets {
+		text = strings.ReplaceAll(text, secret, RedactedValue)
+	}
+	return text
+}
+
+// sortSecrets sorts the secrets longest first, so that secrets containing other
+// secrets are masked entirely.
+func sortSecrets(secrets []string) {
+	sort.Slice(secrets, func(i, j int) bool {
+		if len(secrets[i]) != len(secrets[j]) {
+			return len(secrets[i]) > len(secrets[j])
+		}
+		return secrets[i] < secrets[j]
+	})
+}
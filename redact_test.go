@@ -0,0 +1,111 @@
+package rq
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRedact(t *testing.T) {
+	t.Run("Secret values are marked", func(t *testing.T) {
+		env := NewEnvironment(map[string]string{"token": "secret:abc123", "host": "localhost"})
+		env.SetSecret("password", "hunter2")
+		env.Set("session", "s1")
+		scope := env.Scope()
+		scope.Set("token", "def456")
+		if diff := cmp.Diff(map[string]string{
+			"token":    "def456",
+			"host":     "localhost",
+			"password": "hunter2",
+			"session":  "s1",
+		}, scope.Strings()); diff != "" {
+			t.Errorf("variables mismatch (-want +got):\n%s", diff)
+		}
+		if diff := cmp.Diff([]string{"hunter2", "def456"}, scope.Secrets()); diff != "" {
+			t.Errorf("secrets mismatch (-want +got):\n%s", diff)
+		}
+		if diff := cmp.Diff("token=****&password=****&session=s1", scope.Redact("token=def456&password=hunter2&session=s1")); diff != "" {
+			t.Errorf("redacted text mismatch (-want +got):\n%s", diff)
+		}
+		env.Delete("password")
+		if env.IsSecret("password") {
+			t.Error("expected the deleted variable not to be secret")
+		}
+		env.Set("data", "secret:not-a-secret")
+		if value, _ := env.Lookup("data"); value != "secret:not-a-secret" || env.IsSecret("data") {
+			t.Errorf("expected the values set to be kept as is, got %q", value)
+		}
+	})
+
+	t.Run("Sensitive headers are masked", func(t *testing.T) {
+		for _, test := range []struct {
+			key, value, expected string
+		}{
+			{"Authorization", "Bearer abc123", "Bearer ****"},
+			{"authorization", "abc123", "****"},
+			{"Proxy-Authorization", "Basic dXNlcjpwYXNz", "Basic ****"},
+			{"Cookie", "session=abc; theme=dark", "****"},
+			{"Set-Cookie", "session=abc; Path=/", "****"},
+			{"Authorization", "Bearer {{token}}", "Bearer {{token}}"},
+			{"Content-Type", "application/json", "application/json"},
+		} {
+			if got := RedactHeader(test.key, test.value); got != test.expected {
+				t.Errorf("%s: %s: expected %q, got %q", test.key, test.value, test.expected, got)
+			}
+		}
+	})
+
+	t.Run("Secrets are masked in the text written by the library", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "s1"})
+			w.Write([]byte(`{"token": "` + r.URL.Query().Get("key") + `"}`))
+		}))
+		defer srv.Close()
+		request := Request{
+			Method: "GET",
+			URL:    "{{host}}/login?key={{key}}",
+			Headers: Headers{
+				{Key: "Authorization", Value: "Bearer {{token}}"},
+				{Key: "X-Api-Key", Value: "{{key}}"},
+			},
+			PreRequestScript:  `log('using ' + getEnv('key'))`,
+			PostRequestScript: `log('token ' + getEnv('token')); setSecret('session', 's-789'); log('session ' + getEnv('session'))`,
+		}
+		ctx := WithEnvironment(context.Background(), map[string]string{
+			"host":  srv.URL,
+			"key":   "secret:k-123",
+			"token": "t-456",
+		})
+
+		applied := applyEnv(t, ctx, request)
+		expected := `< {% log('using ' + getEnv('key')) %}
+GET ` + srv.URL + `/login?key=****
+Authorization: Bearer ****
+X-Api-Key: ****
+
+< {% log('token ' + getEnv('token')); setSecret('session', 's-789'); log('session ' + getEnv('session')) %}
+`
+		if diff := cmp.Diff(expected, applied.String()); diff != "" {
+			t.Errorf("request mismatch (-want +got):\n%s", diff)
+		}
+		if !strings.Contains(applied.HttpText(), "k-123") {
+			t.Error("expected HttpText not to be masked")
+		}
+
+		resp, err := request.Do(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]string{"using ****", "token t-456", "session ****"}, request.Logs); diff != "" {
+			t.Errorf("logs mismatch (-want +got):\n%s", diff)
+		}
+		output := resp.String()
+		if strings.Contains(output, "k-123") || strings.Contains(output, "session=s1") || !strings.Contains(output, "Set-Cookie: ****") {
+			t.Errorf("expected the secrets to be masked, got:\n%s", output)
+		}
+	})
+}
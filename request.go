@@ -1,17 +1,15 @@
 package rq
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
 	"path"
 	"path/filepath"
-	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -24,14 +22,6 @@ var (
 	ErrSkipped        = errors.New("request skipped")
 )
 
-var (
-	headerRegexp        = regexp.MustCompile(`^([^:]+):\s*(.*)`)
-	scriptStartRegexp   = regexp.MustCompile(`^<\s*\{%(.*)`)
-	scriptFileRegexp    = regexp.MustCompile(`^<\s*(.*\.js)`)
-	scriptEndRegexp     = regexp.MustCompile(`(.*)%\}`)
-	scriptOneLineRegexp = regexp.MustCompile(`^<\s*\{%(.*)%\}`)
-)
-
 // Request is a struct that holds the HTTP request data.
 type Request struct {
 	// The name of the request
@@ -53,6 +43,19 @@ type Request struct {
 
 	// The URL of the request
 	URL string
+
+	// MultilineURL is set when the query parameters of the URL are written on indented
+	// continuation lines after the request line, HttpText then writes them back that way.
+	// Example:
+	//	GET http://localhost/users
+	//	    ?page=1
+	//	    &limit=50
+	MultilineURL bool
+
+	// Proto is the optional HTTP version given after the URL of the request line.
+	// Example: HTTP/1.1
+	Proto string
+
 	// The HTTP body
 	// Note: The body is not parsed.
 	// Example: {"foo":"bar"}
@@ -60,14 +63,65 @@ type Request struct {
 	// Example: foo=bar&baz=qux
 	Body string
 
+	// BodyFile is the path of the file the body was loaded from with `< ./payload.json`,
+	// relative to the .http file. The content of the file is loaded into Body as is.
+	BodyFile string
+
+	// SubstituteBodyFile is set when the body file was included with `<@ ./payload.json`,
+	// the {{variables}} in the content of the file are then replaced like in an inline body.
+	SubstituteBodyFile bool
+
+	// Parts holds the parts of a multipart/form-data body, they are parsed from Body when
+	// the Content-Type header has a boundary and are used instead of Body to send the request.
+	Parts []Part
+
 	// The http Headers
 	Headers Headers
 
+	// Variables holds the file variables declared with `@name = value` before the request
+	// line. Values may reference other variables and are overridden by the environment.
+	// Example: {"host": "http://localhost:8080"}
+	Variables map[string]string
+
+	// Annotations holds the `# @key value` comments given before the request line,
+	// see the Annotation constants for the annotations that are honoured by Do.
+	// Example: {"no-redirect": "", "timeout": "5s"}
+	Annotations map[string]string
+
+	// Tags is the list of tags given with `# @tag` annotations.
+	// Example: # @tag smoke
+	Tags []string
+
+	// ResponseFile is the path the response body is saved to with `>> ./out/user.json`,
+	// relative to the .http file, or to the working directory when the request was parsed
+	// from an fs.FS, which cannot be written to. {{variables}} are replaced in the path and
+	// a unique suffix is added to the file name when the file already exists.
+	ResponseFile string
+
+	// OverwriteResponseFile is set when the response is redirected with `>>! ./out/user.json`,
+	// an existing file is then overwritten.
+	OverwriteResponseFile bool
+
+	// File is the path of the .http file the request was parsed from.
+	File string
+
+	// FromFS is set when the request was parsed from an fs.FS with ParseFS or WithFS,
+	// File is then a path of the fs.FS rather than of the OS filesystem.
+	FromFS bool
+
+	// Line is the 1-based line of the request line in File.
+	Line int
+
 	// Skip is a flag that indicates if the request should be skipped
 	Skip bool
 
-	// Logs is a list of logs generated by any scripts in the request
+	// Logs is a list of logs generated by any scripts in the request, the secret values
+	// of the environment are masked.
 	Logs []string
+
+	// Secrets holds the secret values of the environment the request was applied with by
+	// ApplyEnv, they are masked by String.
+	Secrets []string
 }
 
 type Headers []Header
@@ -77,6 +131,16 @@ type Header struct {
 	Value string
 }
 
+// Get returns the value of the first header with the key, the key is case-insensitive.
+func (h Headers) Get(key string) string {
+	for _, header := range h {
+		if strings.EqualFold(header.Key, key) {
+			return header.Value
+		}
+	}
+	return ""
+}
+
 func (r Request) DisplayName() string {
 	if r.Name != "" {
 		return r.Name
@@ -84,28 +148,85 @@ func (r Request) DisplayName() string {
 	return fmt.Sprintf("%s %s", r.Method, r.URL)
 }
 
+// String returns the request in the .http file syntax, with the Secrets and the values
+// of the Authorization and Cookie headers masked, see RedactHeader.
 func (r Request) String() string {
 	var buffer bytes.Buffer
 	if r.Name != "" {
 		buffer.WriteString(fmt.Sprintf("%s %s\n", RequestSeparator, r.Name))
 	}
+	keys := make([]string, 0, len(r.Annotations))
+	for key := range r.Annotations {
+		if key != AnnotationName {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		buffer.WriteString(strings.TrimSpace(fmt.Sprintf("# @%s %s", key, r.Annotations[key])) + "\n")
+	}
+	for _, tag := range r.Tags {
+		buffer.WriteString(fmt.Sprintf("# @%s %s\n", AnnotationTag, tag))
+	}
 	if r.PreRequestScript != "" {
-		buffer.WriteString(fmt.Sprintf("\n\n<{%% %s %%}\n\n", r.PreRequestScript))
+		buffer.WriteString(formatScript(r.PreRequestScript) + "\n")
 	}
-	buffer.WriteString(r.HttpText())
+	buffer.WriteString(r.httpText(true))
 	if r.PostRequestScript != "" {
-		buffer.WriteString(fmt.Sprintf("\n\n<{%% %s %%}\n\n", r.PostRequestScript))
+		if !strings.HasSuffix(buffer.String(), "\n") {
+			buffer.WriteString("\n")
+		}
+		buffer.WriteString("\n" + formatScript(r.PostRequestScript) + "\n")
 	}
-	return buffer.String()
+	switch {
+	case r.ResponseFile != "" && r.OverwriteResponseFile:
+		fmt.Fprintf(&buffer, "\n>>! %s\n", r.ResponseFile)
+	case r.ResponseFile != "":
+		fmt.Fprintf(&buffer, "\n>> %s\n", r.ResponseFile)
+	}
+	return redact(buffer.String(), r.Secrets)
 }
 
+// HttpText returns the request line, headers and body of the request.
 func (r Request) HttpText() string {
+	return r.httpText(false)
+}
+
+// httpText returns the request line, headers and body of the request, with the values
+// of the sensitive headers masked when redact is set.
+func (r Request) httpText(redact bool) string {
 	var buffer strings.Builder
-	fmt.Fprintf(&buffer, "%s %s\n", r.Method, r.URL)
+	url, query, multiline := r.URL, "", false
+	if r.MultilineURL {
+		url, query, multiline = strings.Cut(r.URL, "?")
+	}
+	fmt.Fprintf(&buffer, "%s %s", r.Method, url)
+	if r.Proto != "" {
+		fmt.Fprintf(&buffer, " %s", r.Proto)
+	}
+	buffer.WriteString("\n")
+	if multiline {
+		for i, param := range strings.Split(query, "&") {
+			separator := "&"
+			if i == 0 {
+				separator = "?"
+			}
+			fmt.Fprintf(&buffer, "    %s%s\n", separator, param)
+		}
+	}
 	for _, header := range r.Headers {
-		fmt.Fprintf(&buffer, "%s: %s\n", header.Key, header.Value)
+		value := header.Value
+		if redact {
+			value = RedactHeader(header.Key, value)
+		}
+		fmt.Fprintf(&buffer, "%s: %s\n", header.Key, value)
 	}
-	if r.Body != "" {
+	switch {
+	case r.BodyFile != "" && r.SubstituteBodyFile:
+		fmt.Fprintf(&buffer, "\n<@ %s\n", r.BodyFile)
+	case r.BodyFile != "":
+		fmt.Fprintf(&buffer, "\n< %s\n", r.BodyFile)
+	case r.Body != "":
 		buffer.WriteString("\n")
 		buffer.WriteString(r.Body)
 	}
@@ -119,18 +240,52 @@ func resolvePath(dir, file string) string {
 	return path.Join(dir, file)
 }
 
-func (r Request) ApplyEnv(ctx context.Context) Request {
-	env := GetEnvironment(ctx)
-	r.Method = replaceVariables(r.Method, env)
-	r.URL = replaceVariables(r.URL, env)
-	r.Body = replaceVariables(r.Body, env)
-	r.Headers = replaceVariablesHeaders(r.Headers, env)
-	return r
+// ApplyEnv replaces the {{variables}} of the request with the values of the environment
+// of the context, falling back to the file variables of the request and then to the
+// environment sources of the context, see WithEnvironmentSources. Variables that cannot
+// be resolved are kept as is, unless the strict mode of WithStrictVariables is enabled,
+// an UnresolvedVariablesError listing them is then returned. The error is returned in
+// any mode for references to the responses of requests that have not run, see History.
+func (r Request) ApplyEnv(ctx context.Context) (Request, error) {
+	t := &templater{lookup: r.lookup(ctx)}
+	r.Method = t.replace(r.Method, "method")
+	r.URL = t.replace(r.URL, "URL")
+	if r.BodyFile == "" || r.SubstituteBodyFile {
+		location := "body"
+		if len(r.Parts) > 0 {
+			// the parts are sent instead of the body, their variables are reported below
+			location = ""
+		}
+		r.Body = t.replace(r.Body, location)
+	}
+	r.Headers = t.headers(r.Headers, "header")
+	if len(r.Parts) > 0 {
+		parts := make([]Part, len(r.Parts))
+		for i, part := range r.Parts {
+			part.Headers = t.headers(part.Headers, fmt.Sprintf("part %d header", i+1))
+			if part.File == "" {
+				part.Body = t.replace(part.Body, fmt.Sprintf("part %d body", i+1))
+			}
+			parts[i] = part
+		}
+		r.Parts = parts
+	}
+	r.Secrets = contextSecrets(ctx)
+	return r, t.err(ctx)
+}
+
+// Variable returns the value of a variable of the request, from the environment of
+// the context, the file variables or the environment sources of the context.
+func (r Request) Variable(ctx context.Context, name string) (string, bool) {
+	value, err := r.lookup(ctx)(name)
+	return value, err == nil
 }
 
 func (r *Request) Do(ctx context.Context) (*Response, error) {
 	rt := getRuntime(ctx)
 	rt.setRequest(r)
+	rt.setStateStore(getStateStore(ctx))
+	rt.secrets = getSecretCache(ctx)
 	defer rt.reset()
 	defer func() {
 		r.Logs = append(r.Logs, rt.extractLogs()...)
@@ -158,20 +313,33 @@ func (r *Request) Do(ctx context.Context) (*Response, error) {
 	if r.Skip {
 		return nil, ErrSkipped
 	}
-	ctx = WithEnvironment(ctx, rt.environment)
-	req, err := r.ApplyEnv(ctx).ToHttpRequest(ctx)
+	ctx = WithEnvironmentScope(ctx, rt.environment)
+	ctx, runner, cancel, err := r.applyAnnotations(ctx, getRequestRunner(ctx))
+	if err != nil {
+		return nil, err
+	}
+	applied, err := r.ApplyEnv(ctx)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
-	rawResp, err := getRequestRunner(ctx).Do(req)
+	req, err := applied.ToHttpRequest(ctx)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
+	rawResp, err := runner.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	rawResp.Body = cancelOnClose{ReadCloser: rawResp.Body, cancel: cancel}
 
 	resp := newResponse(rawResp)
 	if r.PostRequestScript != "" {
 		rt.setResponse(resp)
 		if err := rt.executeScript(r.PostRequestScript); err != nil {
+			resp.Body.Close()
 			return nil, err
 		}
 		resp.PostRequestAssertions = rt.extractAssertions()
@@ -182,6 +350,29 @@ func (r *Request) Do(ctx context.Context) (*Response, error) {
 		}
 
 	}
+	resp.secrets = contextSecrets(ctx)
+	if history := GetHistory(ctx); history != nil && r.Name != "" {
+		if err := history.record(r.Name, resp); err != nil {
+			// closing the body cancels the context of the @timeout annotation
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+	if r.ResponseFile != "" {
+		t := &templater{lookup: r.lookup(ctx)}
+		name := t.replace(r.ResponseFile, "response file")
+		if err := t.err(ctx); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		if !filepath.IsAbs(name) && r.File != "" && !r.FromFS {
+			name = filepath.Join(filepath.Dir(r.File), name)
+		}
+		if err := resp.save(name, r.OverwriteResponseFile); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
 	return resp, nil
 }
 
@@ -190,171 +381,33 @@ func (r Request) ToHttpRequest(ctx context.Context) (*http.Request, error) {
 	if err != nil {
 		return nil, err
 	}
-	for _, header := range r.Headers {
-		req.Header.Set(header.Key, header.Value)
-	}
-	return req, nil
-}
-
-func replaceVariablesHeaders(headers Headers, variables map[string]string) Headers {
-	var result Headers
-	for _, header := range headers {
-		result = append(result, Header{
-			Key:   replaceVariables(header.Key, variables),
-			Value: replaceVariables(header.Value, variables),
-		})
-	}
-	return result
-}
-
-func ParseRequests(input string) ([]Request, error) {
-	return parseRequests("", input)
-}
-
-func ParseFromFile(path string) ([]Request, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
-	dir := filepath.Dir(path)
-	return parseRequests(dir, string(data))
-}
-
-func parseRequests(dir, input string) ([]Request, error) {
-	scanner := bufio.NewScanner(bytes.NewBufferString(input))
-	var requests []Request
-	var currentRequest *Request
-	headerParsed := false
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, RequestSeparator) {
-			headerParsed = false
-			if currentRequest != nil {
-				requests = append(requests, *currentRequest)
-			}
-			currentRequest = &Request{
-				Name: strings.TrimSpace(line[3:]),
-			}
-		} else {
-			if currentRequest == nil {
-				currentRequest = &Request{}
-			}
-			if currentRequest.Method == "" {
-				if strings.HasPrefix(strings.TrimSpace(line), "<") {
-					currentRequest.PreRequestScript, _ = parseRequestScript(dir, scanner)
-					continue
-				}
-				if err := parseMethodAndURL(currentRequest, line); err != nil {
-					return nil, err
-				}
-			} else {
-				if !headerParsed {
-					currentRequest.Headers = parseHeaders(scanner)
-					headerParsed = true
-					continue
-				}
-				if line == "" {
-					continue
-				}
-				if script, ok := parseRequestScript(dir, scanner); ok {
-					currentRequest.PostRequestScript = script
-					continue
-				}
-				if currentRequest.PostRequestScript == "" {
-					currentRequest.Body += line + "\n"
-				}
-			}
+	if boundary, ok := multipartBoundary(r.Headers); ok && len(r.Parts) > 0 {
+		// stream the parts to the request body rather than buffering the whole payload,
+		// GetBody streams them again when a redirect resends the body
+		req.GetBody = func() (io.ReadCloser, error) {
+			reader, writer := io.Pipe()
+			go func() {
+				writer.CloseWithError(writeParts(writer, boundary, r.Parts))
+			}()
+			return reader, nil
 		}
+		req.Body, _ = req.GetBody()
+		req.ContentLength = -1
 	}
-
-	if currentRequest != nil {
-		requests = append(requests, *currentRequest)
-	}
-
-	if err := scanner.Err(); err != nil {
-		return nil, err
-	}
-	return requests, nil
-}
-
-func parseRequestScript(dir string, scanner *bufio.Scanner) (string, bool) {
-	var script strings.Builder
-	line := strings.TrimSpace(scanner.Text())
-	if match := scriptFileRegexp.FindStringSubmatch(line); match != nil {
-		// the script is in a file at the path defined after the '<', read the script from the file
-		// read the file
-		data := strings.TrimSpace(match[1])
-		file, err := os.Open(resolvePath(dir, data))
-		if err != nil {
-			panic(err)
+	if r.Proto != "" {
+		proto := r.Proto
+		if !strings.Contains(proto, ".") {
+			// HTTP/2 and HTTP/3 are commonly written without a minor version
+			proto += ".0"
 		}
-		defer file.Close()
-
-		b, err := io.ReadAll(file)
-		if err != nil {
-			panic(err)
-		}
-		return string(b), true
-	}
-	if match := scriptOneLineRegexp.FindStringSubmatch(line); match != nil {
-		return strings.TrimSpace(match[1]), true
-	}
-	if match := scriptStartRegexp.FindStringSubmatch(line); match != nil {
-		script.WriteString(strings.TrimSpace(match[1]) + "\n")
-	} else {
-		return "", false
-	}
-	for scanner.Scan() {
-		line = strings.TrimSpace(scanner.Text())
-		if match := scriptEndRegexp.FindStringSubmatch(line); match != nil {
-			script.WriteString(strings.TrimSpace(match[1]))
-			return script.String(), true
-		}
-		script.WriteString(strings.TrimSpace(line) + "\n")
-	}
-	return script.String(), true
-}
-
-func parseHeaders(scanner *bufio.Scanner) Headers {
-	var headers Headers
-	line := scanner.Text()
-	k, v, ok := parseHeader(line)
-	if !ok {
-		return headers
-	}
-	headers = append(headers, Header{Key: k, Value: v})
-	for scanner.Scan() {
-		line = scanner.Text()
-		k, v, ok := parseHeader(line)
+		major, minor, ok := http.ParseHTTPVersion(proto)
 		if !ok {
-			return headers
+			return nil, fmt.Errorf("%w: malformed HTTP version %q", ErrInvalidRequest, r.Proto)
 		}
-		headers = append(headers, Header{Key: k, Value: v})
+		req.Proto, req.ProtoMajor, req.ProtoMinor = r.Proto, major, minor
 	}
-	return headers
-}
-
-func parseHeader(line string) (string, string, bool) {
-	match := headerRegexp.FindStringSubmatch(line)
-	if match == nil {
-		return "", "", false
-	}
-	return match[1], match[2], true
-}
-
-func parseMethodAndURL(req *Request, line string) error {
-	if req.Method != "" || req.URL != "" {
-		return fmt.Errorf("Request method and target has already been parsed %w", ErrInvalidRequest)
-	}
-	if line == "" {
-		return nil
-	}
-	if match := regexp.MustCompile(`^(GET|POST|PUT|DELETE|PATCH|OPTIONS|HEAD)\s+(.+)$`).FindStringSubmatch(line); match != nil {
-		req.Method = match[1]
-		req.URL = match[2]
-	} else {
-		return fmt.Errorf("Request does not include method or URL %w", ErrInvalidRequest)
+	for _, header := range r.Headers {
+		req.Header.Set(header.Key, header.Value)
 	}
-	return nil
+	return req, nil
 }
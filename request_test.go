@@ -3,16 +3,23 @@ package rq
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"testing/iotest"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
 func ExampleRequest() {
@@ -103,6 +110,7 @@ Authorization: Bearer {{token}}
 				Method:           "GET",
 				PreRequestScript: "foo baz bar",
 				URL:              "http://localhost:3838/users/123?fizz=buzz",
+				Line:             3,
 				Headers: []Header{
 					{"Accept", "application/json"},
 					{"Authorization", "Bearer {{token}}"},
@@ -144,6 +152,7 @@ Authorization: Bearer {{token}}
 				PreRequestScript:  "foo\nbaz\nbar",
 				PostRequestScript: "foo\nbaz\nbar",
 				URL:               "http://localhost:3838/users/123?fizz=buzz",
+				Line:              3,
 				Headers: []Header{
 					{"Accept", "application/json"},
 					{"Authorization", "Bearer {{token}}"},
@@ -184,6 +193,7 @@ bar %}
 				PreRequestScript:  "foo\nbaz\nbar",
 				PostRequestScript: "foo\nbaz\nbar",
 				URL:               "http://localhost:3838/users/123?fizz=buzz",
+				Line:              5,
 				Headers: []Header{
 					{"Content-Type", "application/json"},
 					{"Accept", "application/json"},
@@ -221,6 +231,7 @@ Content-Type: application/json
 				Name:   "Get User",
 				Method: "GET",
 				URL:    "http://localhost:3838/users/123?fizz=buzz",
+				Line:   2,
 				Headers: []Header{
 					{"Accept", "application/json"},
 					{"Authorization", "Bearer {{token}}"},
@@ -230,6 +241,7 @@ Content-Type: application/json
 				Name:   "Create a User",
 				Method: "POST",
 				URL:    "http://localhost:3838/users",
+				Line:   7,
 				Headers: []Header{
 					{"Content-Type", "application/json"},
 				},
@@ -244,6 +256,606 @@ Content-Type: application/json
 	})
 }
 
+func TestParseRequests_requestLine(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected Request
+	}{
+		{"TRACE http://localhost/users", Request{Method: "TRACE", URL: "http://localhost/users", Line: 1}},
+		{"PROPFIND /files/ HTTP/1.1", Request{Method: "PROPFIND", URL: "/files/", Proto: "HTTP/1.1", Line: 1}},
+		{"X-PURGE_CACHE {{host}}/cache", Request{Method: "X-PURGE_CACHE", URL: "{{host}}/cache", Line: 1}},
+		{"GET {{host}}/users/{{$randomInt 1 10}} HTTP/2", Request{Method: "GET", URL: "{{host}}/users/{{$randomInt 1 10}}", Proto: "HTTP/2", Line: 1}},
+		{"https://example.com", Request{Method: "GET", URL: "https://example.com", Line: 1}},
+		{"  {{host}}/users HTTP/1.0", Request{Method: "GET", URL: "{{host}}/users", Proto: "HTTP/1.0", Line: 1}},
+		{"GET http://localhost/search?q=a b", Request{Method: "GET", URL: "http://localhost/search?q=a b", Line: 1}},
+		{"GET /search?q=a b HTTP/1.1", Request{Method: "GET", URL: "/search?q=a b", Proto: "HTTP/1.1", Line: 1}},
+		{"/search?q=a b", Request{Method: "GET", URL: "/search?q=a b", Line: 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.line, func(t *testing.T) {
+			requests, err := ParseRequests(test.line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff([]Request{test.expected}, requests); diff != "" {
+				t.Errorf("requests mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("The HTTP version is set on the http.Request", func(t *testing.T) {
+		req, err := Request{Method: "GET", URL: "http://localhost/", Proto: "HTTP/2"}.ToHttpRequest(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.Proto != "HTTP/2" || req.ProtoMajor != 2 || req.ProtoMinor != 0 {
+			t.Errorf("unexpected protocol %q %d.%d", req.Proto, req.ProtoMajor, req.ProtoMinor)
+		}
+		if text := (Request{Method: "GET", URL: "/", Proto: "HTTP/2"}).HttpText(); text != "GET / HTTP/2\n" {
+			t.Errorf("unexpected http text %q", text)
+		}
+	})
+}
+
+func TestParseRequests_annotations(t *testing.T) {
+	t.Run("Comments are ignored and annotations are parsed", func(t *testing.T) {
+		input := `# Users API
+// shared by the whole team
+
+### Get User
+# @name Get The User
+// @no-redirect
+# @timeout 5s
+# @tag smoke, users
+# @tag regression
+# the user must exist
+GET http://localhost:3838/users/123
+# authentication
+Authorization: Bearer {{token}}
+// Accept: text/plain
+Accept: application/json
+
+# a body line
+`
+
+		requests, err := ParseRequests(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]Request{
+			{
+				Name:   "Get The User",
+				Method: "GET",
+				URL:    "http://localhost:3838/users/123",
+				Line:   11,
+				Headers: []Header{
+					{"Authorization", "Bearer {{token}}"},
+					{"Accept", "application/json"},
+				},
+				Body: "# a body line\n",
+				Annotations: map[string]string{
+					"name":        "Get The User",
+					"no-redirect": "",
+					"timeout":     "5s",
+				},
+				Tags: []string{"smoke", "users", "regression"},
+			},
+		}, requests); diff != "" {
+			t.Errorf("requests mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("A separator directly after the headers starts a new request", func(t *testing.T) {
+		requests, err := ParseRequests("GET /a\nAccept: text/plain\n### B\nGET /b\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]Request{
+			{Method: "GET", URL: "/a", Headers: []Header{{"Accept", "text/plain"}}, Line: 1},
+			{Name: "B", Method: "GET", URL: "/b", Line: 4},
+		}, requests); diff != "" {
+			t.Errorf("requests mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("An invalid timeout is reported", func(t *testing.T) {
+		_, err := ParseRequests("# @timeout soon\nGET /users\n")
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) || parseErr.Line != 1 {
+			t.Errorf("expected a parse error on line 1, got %v", err)
+		}
+	})
+
+	t.Run("Annotations are written by String", func(t *testing.T) {
+		request := Request{
+			Name:        "Get User",
+			Method:      "GET",
+			URL:         "/users",
+			Annotations: map[string]string{"name": "Get User", "timeout": "5s", "no-redirect": ""},
+			Tags:        []string{"smoke"},
+		}
+		expected := `### Get User
+# @no-redirect
+# @timeout 5s
+# @tag smoke
+GET /users
+`
+		if diff := cmp.Diff(expected, request.String()); diff != "" {
+			t.Errorf("request mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestParseRequests_variables(t *testing.T) {
+	t.Run("File variables declared before a request are available to it", func(t *testing.T) {
+		input := `@host = http://localhost:8080
+@api = {{host}}/v1
+
+### Get User
+GET {{api}}/users/1
+
+### Get User v2
+@api = {{host}}/v2
+GET {{api}}/users/1
+`
+
+		requests, err := ParseRequests(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]Request{
+			{
+				Name:      "Get User",
+				Method:    "GET",
+				URL:       "{{api}}/users/1",
+				Line:      5,
+				Variables: map[string]string{"host": "http://localhost:8080", "api": "{{host}}/v1"},
+			},
+			{
+				Name:      "Get User v2",
+				Method:    "GET",
+				URL:       "{{api}}/users/1",
+				Line:      9,
+				Variables: map[string]string{"host": "http://localhost:8080", "api": "{{host}}/v2"},
+			},
+		}, requests); diff != "" {
+			t.Errorf("requests mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestParseRequests_multilineURL(t *testing.T) {
+	input := `### List Users
+GET http://localhost:3838/users HTTP/1.1
+    ?page=1
+    &limit=50
+	&sort={{sort}}
+Accept: application/json
+`
+
+	requests, err := ParseRequests(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Request{
+		Name:         "List Users",
+		Method:       "GET",
+		URL:          "http://localhost:3838/users?page=1&limit=50&sort={{sort}}",
+		Line:         2,
+		MultilineURL: true,
+		Proto:        "HTTP/1.1",
+		Headers:      []Header{{"Accept", "application/json"}},
+	}
+	if diff := cmp.Diff([]Request{expected}, requests); diff != "" {
+		t.Errorf("requests mismatch (-want +got):\n%s", diff)
+	}
+
+	t.Run("The multi-line form is written back by String", func(t *testing.T) {
+		output := requests[0].String()
+		if diff := cmp.Diff(`### List Users
+GET http://localhost:3838/users HTTP/1.1
+    ?page=1
+    &limit=50
+    &sort={{sort}}
+Accept: application/json
+`, output); diff != "" {
+			t.Errorf("request mismatch (-want +got):\n%s", diff)
+		}
+		reparsed, err := ParseRequests(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(requests, reparsed); diff != "" {
+			t.Errorf("round trip mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestParseRequests_bodyFiles(t *testing.T) {
+	binary := string([]byte{0x89, 'P', 'N', 'G', 0x00, 0xff, '\n'})
+	fsys := fstest.MapFS{
+		"api/users.http": {Data: []byte(`### Create User
+POST {{host}}/users
+Content-Type: application/json
+
+<@ ./payload.json
+
+### Create Raw User
+POST {{host}}/users
+Content-Type: application/json
+
+< ./payload.json
+
+### Upload Avatar
+PUT {{host}}/users/1/avatar
+Content-Type: image/png
+
+< ../images/avatar.png
+
+< ./assert.js
+`)},
+		"api/payload.json":  {Data: []byte(`{"name": "{{name}}"}`)},
+		"api/assert.js":     {Data: []byte(`assert(true, 'ok')`)},
+		"images/avatar.png": {Data: []byte(binary)},
+	}
+
+	requests, err := ParseFS(fsys, "api/users.http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 3 {
+		t.Fatalf("expected 3 requests, got %d", len(requests))
+	}
+	ctx := WithEnvironment(context.Background(), map[string]string{"name": "Fred"})
+
+	t.Run("Variables are replaced in body files included with <@", func(t *testing.T) {
+		if body := applyEnv(t, ctx, requests[0]).Body; body != `{"name": "Fred"}` {
+			t.Errorf("unexpected body %q", body)
+		}
+	})
+
+	t.Run("Body files included with < are sent as is", func(t *testing.T) {
+		if body := applyEnv(t, ctx, requests[1]).Body; body != `{"name": "{{name}}"}` {
+			t.Errorf("unexpected body %q", body)
+		}
+	})
+
+	t.Run("Binary body files are preserved", func(t *testing.T) {
+		if requests[2].Body != binary {
+			t.Errorf("unexpected body %q", requests[2].Body)
+		}
+		if requests[2].PostRequestScript != "assert(true, 'ok')" {
+			t.Errorf("unexpected post-request script %q", requests[2].PostRequestScript)
+		}
+	})
+
+	t.Run("The include is written back by String", func(t *testing.T) {
+		expected := `### Create User
+POST {{host}}/users
+Content-Type: application/json
+
+<@ ./payload.json
+`
+		if diff := cmp.Diff(expected, requests[0].String()); diff != "" {
+			t.Errorf("request mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("XML bodies are not taken for body files", func(t *testing.T) {
+		body := `<?xml version="1.0"?>
+<soap:Envelope xmlns:soap="http://www.w3.org/2003/05/soap-envelope">
+  <soap:Body/>
+</soap:Envelope>`
+		requests, err := ParseRequests("POST /soap\nContent-Type: application/soap+xml\n\n"+body+"\n", WithFS(fstest.MapFS{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if requests[0].Body != body+"\n" {
+			t.Errorf("unexpected body %q", requests[0].Body)
+		}
+	})
+
+	t.Run("A missing body file is reported", func(t *testing.T) {
+		_, err := ParseRequests("POST /users\n\n< ./missing.json\n", WithFS(fstest.MapFS{}))
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) || parseErr.Line != 3 || !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected a parse error on line 3, got %v", err)
+		}
+	})
+}
+
+func TestParseRequests_multipart(t *testing.T) {
+	fsys := fstest.MapFS{
+		"api/upload.http": {Data: []byte(`### Upload Document
+POST {{host}}/documents
+Content-Type: multipart/form-data; boundary=WebAppBoundary
+
+--WebAppBoundary
+Content-Disposition: form-data; name="title"
+
+{{title}}
+
+second line
+--WebAppBoundary
+Content-Disposition: form-data; name="file"; filename="report.pdf"
+Content-Type: application/pdf
+
+< ./report.pdf
+--WebAppBoundary--
+
+< {% assert(request.parts[1].filename === 'report.pdf', 'the file part is exposed') %}
+`)},
+		"api/report.pdf": {Data: []byte("%PDF-1.4\x00\xff")},
+	}
+
+	requests, err := ParseFS(fsys, "api/upload.http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]Part{
+		{
+			Headers: []Header{{"Content-Disposition", `form-data; name="title"`}},
+			Body:    "{{title}}\n\nsecond line",
+		},
+		{
+			Headers: []Header{
+				{"Content-Disposition", `form-data; name="file"; filename="report.pdf"`},
+				{"Content-Type", "application/pdf"},
+			},
+			Body: "%PDF-1.4\x00\xff",
+			File: "./report.pdf",
+		},
+	}, requests[0].Parts); diff != "" {
+		t.Errorf("parts mismatch (-want +got):\n%s", diff)
+	}
+
+	t.Run("The parts are sent as a multipart body", func(t *testing.T) {
+		var title, content, filename string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := r.ParseMultipartForm(1 << 20); err != nil {
+				t.Error(err)
+				return
+			}
+			title = r.FormValue("title")
+			file, header, err := r.FormFile("file")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer file.Close()
+			b, _ := io.ReadAll(file)
+			content, filename = string(b), header.Filename
+		}))
+		defer srv.Close()
+
+		request := requests[0]
+		request.PreRequestScript = "assert(request.parts.length === 2, 'the parts are exposed')"
+		_, err := request.Do(WithEnvironment(context.Background(), map[string]string{
+			"host":  srv.URL,
+			"title": "Quarterly Report",
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if title != "Quarterly Report\n\nsecond line" || content != "%PDF-1.4\x00\xff" || filename != "report.pdf" {
+			t.Errorf("unexpected form values %q %q %q", title, content, filename)
+		}
+		if len(request.PreRequestAssertions) != 1 || !request.PreRequestAssertions[0].Success {
+			t.Errorf("unexpected assertions %v", request.PreRequestAssertions)
+		}
+	})
+
+	t.Run("The parts are sent again on 307 redirects", func(t *testing.T) {
+		var title string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/documents" {
+				http.Redirect(w, r, "/uploads", http.StatusTemporaryRedirect)
+				return
+			}
+			title = r.FormValue("title")
+		}))
+		defer srv.Close()
+
+		_, err := requests[0].Do(WithEnvironment(context.Background(), map[string]string{
+			"host":  srv.URL,
+			"title": "Quarterly Report",
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if title != "Quarterly Report\n\nsecond line" {
+			t.Errorf("unexpected title %q", title)
+		}
+	})
+
+	t.Run("An invalid URL is reported", func(t *testing.T) {
+		request := requests[0]
+		request.URL = "://documents"
+		if _, err := request.ToHttpRequest(context.Background()); err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("A missing part file is reported on its line", func(t *testing.T) {
+		input := "POST /documents\nContent-Type: multipart/form-data; boundary=b\n\n--b\nContent-Disposition: form-data; name=\"file\"\n\n< ./missing.pdf\n--b--\n"
+		_, err := ParseRequests(input, WithFS(fstest.MapFS{}))
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) || parseErr.Line != 7 {
+			t.Errorf("expected a parse error on line 7, got %v", err)
+		}
+	})
+}
+
+func TestParseRequests_errors(t *testing.T) {
+	t.Run("An invalid request line is reported with its position", func(t *testing.T) {
+		input := `### Get User
+GET http://localhost:3838/users/123
+
+### Broken
+  fetch the user
+Accept: application/json
+`
+
+		requests, err := ParseRequests(input)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Fatalf("expected a *ParseError, got %v", err)
+		}
+		if !errors.Is(err, ErrInvalidRequest) {
+			t.Errorf("expected error to match ErrInvalidRequest, got %v", err)
+		}
+		if diff := cmp.Diff(&ParseError{
+			Line:   5,
+			Column: 3,
+			Text:   "  fetch the user",
+		}, parseErr, cmpopts.IgnoreFields(ParseError{}, "Err")); diff != "" {
+			t.Errorf("error mismatch (-want +got):\n%s", diff)
+		}
+		if len(requests) != 1 || requests[0].Name != "Get User" {
+			t.Errorf("expected the valid request to be returned, got %v", requests)
+		}
+	})
+
+	t.Run("Every invalid request of a file is reported", func(t *testing.T) {
+		dir := t.TempDir()
+		file := path.Join(dir, "broken.http")
+		input := `### One
+not a request
+
+### Two
+GET /two
+
+### Three
+send /three now
+`
+		if err := os.WriteFile(file, []byte(input), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := ParseFromFile(file)
+		var parseErrs ParseErrors
+		if !errors.As(err, &parseErrs) {
+			t.Fatalf("expected ParseErrors, got %v", err)
+		}
+		var lines []int
+		for _, parseErr := range parseErrs {
+			if parseErr.File != file {
+				t.Errorf("expected file %q, got %q", file, parseErr.File)
+			}
+			lines = append(lines, parseErr.Line)
+		}
+		if diff := cmp.Diff([]int{2, 8}, lines); diff != "" {
+			t.Errorf("lines mismatch (-want +got):\n%s", diff)
+		}
+		expected := file + `:2:1: invalid request: request does not include method or URL: "not a request"`
+		if !strings.HasPrefix(err.Error(), expected) {
+			t.Errorf("expected message to start with %q, got %q", expected, err.Error())
+		}
+	})
+}
+
+func TestParseRequests_scriptFiles(t *testing.T) {
+	t.Run("A missing script file is reported as a parse error", func(t *testing.T) {
+		input := `### Get User
+GET http://localhost:3838/users/123
+
+< missing.js
+`
+
+		_, err := ParseRequests(input, WithFS(fstest.MapFS{}))
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Fatalf("expected a *ParseError, got %v", err)
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected error to match fs.ErrNotExist, got %v", err)
+		}
+		if parseErr.Line != 4 || parseErr.Text != "< missing.js" {
+			t.Errorf("unexpected error position: %v", parseErr)
+		}
+		if !strings.Contains(err.Error(), "missing.js") {
+			t.Errorf("expected the error to name the missing file, got %q", err.Error())
+		}
+	})
+
+	t.Run("Script files are resolved in the provided fs.FS", func(t *testing.T) {
+		input := `### Get User
+< scripts/pre.js
+GET http://localhost:3838/users/123
+
+< scripts/post.js
+`
+		fsys := fstest.MapFS{
+			"scripts/pre.js":  {Data: []byte("log('pre')")},
+			"scripts/post.js": {Data: []byte("log('post')")},
+		}
+
+		requests, err := ParseRequests(input, WithFS(fsys))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]Request{
+			{
+				Name:              "Get User",
+				Method:            "GET",
+				URL:               "http://localhost:3838/users/123",
+				Line:              3,
+				PreRequestScript:  "log('pre')",
+				PostRequestScript: "log('post')",
+				FromFS:            true,
+			},
+		}, requests); diff != "" {
+			t.Errorf("requests mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestParseFS(t *testing.T) {
+	t.Run("Script files are resolved relative to the parsed file", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"api/users.http": {Data: []byte(`### Get User
+GET http://localhost:3838/users/123
+
+< scripts/assert.js
+`)},
+			"api/scripts/assert.js": {Data: []byte("assert(true, 'ok')")},
+		}
+
+		requests, err := ParseFS(fsys, "api/users.http")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]Request{
+			{
+				Name:              "Get User",
+				Method:            "GET",
+				URL:               "http://localhost:3838/users/123",
+				Line:              2,
+				PostRequestScript: "assert(true, 'ok')",
+				File:              "api/users.http",
+				FromFS:            true,
+			},
+		}, requests); diff != "" {
+			t.Errorf("requests mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Errors report the path of the file in the fs.FS", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"api/users.http": {Data: []byte("### Get User\n< ../../outside.js\nGET /users\n")},
+		}
+
+		_, err := ParseFS(fsys, "api/users.http")
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Fatalf("expected a *ParseError, got %v", err)
+		}
+		if parseErr.File != "api/users.http" || parseErr.Line != 2 {
+			t.Errorf("unexpected error position: %v", parseErr)
+		}
+	})
+}
+
 func TestRequest_applyEnv(t *testing.T) {
 	t.Run("Variables are replaced", func(t *testing.T) {
 		request := Request{
@@ -255,13 +867,40 @@ func TestRequest_applyEnv(t *testing.T) {
 		}
 		expected := `GET http://localhost:3838/users/1234
 Authorization: Bearer abc123` + "\n"
-		if diff := cmp.Diff(expected, request.ApplyEnv(WithEnvironment(context.Background(), map[string]string{
+		if diff := cmp.Diff(expected, applyEnv(t, WithEnvironment(context.Background(), map[string]string{
 			"id":    "1234",
 			"token": "abc123",
-		})).String()); diff != "" {
+		}), request).HttpText()); diff != "" {
 			t.Errorf("request mismatch (-want +got):\n%s", diff)
 		}
 	})
+	t.Run("The environment takes precedence over file variables", func(t *testing.T) {
+		request := Request{
+			Method: "GET",
+			URL:    "{{api}}/users/{{id}}",
+			Variables: map[string]string{
+				"api":  "{{host}}/v1",
+				"host": "http://localhost:8080",
+				"id":   "1",
+			},
+		}
+		applied := applyEnv(t, WithEnvironment(context.Background(), map[string]string{
+			"host": "https://staging.example.com",
+		}), request)
+		if applied.URL != "https://staging.example.com/v1/users/1" {
+			t.Errorf("unexpected url %q", applied.URL)
+		}
+	})
+}
+
+// applyEnv applies the environment of ctx to the request and fails the test on errors.
+func applyEnv(t *testing.T, ctx context.Context, request Request) Request {
+	t.Helper()
+	applied, err := request.ApplyEnv(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return applied
 }
 
 func TestRequest_Do(t *testing.T) {
@@ -300,6 +939,146 @@ func TestRequest_Do(t *testing.T) {
 		}
 	})
 
+	t.Run("The body is closed when the response cannot be handled", func(t *testing.T) {
+		for name, test := range map[string]struct {
+			request Request
+			body    io.Reader
+		}{
+			"post-request script": {Request{Method: "GET", URL: "/", PostRequestScript: "throw new Error('failed')"}, strings.NewReader("ok")},
+			"history":             {Request{Name: "get", Method: "GET", URL: "/"}, iotest.ErrReader(errors.New("broken"))},
+			"response file":       {Request{Method: "GET", URL: "/", ResponseFile: filepath.Join(t.TempDir(), "out.json")}, iotest.ErrReader(errors.New("broken"))},
+		} {
+			t.Run(name, func(t *testing.T) {
+				body := &trackedBody{Reader: test.body}
+				ctx := WithHistory(WithRequestRunner(context.Background(), bodyRunner{body: body}), NewHistory())
+				if _, err := test.request.Do(ctx); err == nil {
+					t.Fatal("expected an error")
+				}
+				if !body.closed {
+					t.Error("expected the body to be closed")
+				}
+			})
+		}
+	})
+
+	t.Run("Redirects are not followed with @no-redirect", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/elsewhere", http.StatusFound)
+		}))
+		defer srv.Close()
+		request := Request{
+			Method:      "GET",
+			URL:         srv.URL,
+			Annotations: map[string]string{AnnotationNoRedirect: ""},
+		}
+		resp, err := request.Do(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusFound {
+			t.Errorf("expected status %d, got %d", http.StatusFound, resp.StatusCode)
+		}
+	})
+
+	t.Run("The request fails once the @timeout elapses", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+		}))
+		defer srv.Close()
+		request := Request{
+			Method:      "GET",
+			URL:         srv.URL,
+			Annotations: map[string]string{AnnotationTimeout: "10ms"},
+		}
+		if _, err := request.Do(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected a deadline exceeded error, got %v", err)
+		}
+	})
+
+	t.Run("The response is saved to the redirection target", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id":1234}`))
+		}))
+		defer srv.Close()
+		dir := t.TempDir()
+		file := path.Join(dir, "users.http")
+		input := `### Append
+GET {{host}}/users/{{id}}
+
+< {% setEnv('name', 'user-' + response.json.id) %}
+
+>> out/{{name}}.json
+
+### Overwrite
+GET {{host}}/users/{{id}}
+
+>>! out/latest.json
+`
+		if err := os.WriteFile(file, []byte(input), 0644); err != nil {
+			t.Fatal(err)
+		}
+		requests, err := ParseFromFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := WithEnvironment(context.Background(), map[string]string{"host": srv.URL, "id": "1234"})
+		for i := 0; i < 2; i++ {
+			for _, request := range requests {
+				resp, err := request.Do(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if body := resp.String(); !strings.HasSuffix(body, `{"id":1234}`) {
+					t.Errorf("expected the response body to remain readable, got %q", body)
+				}
+			}
+		}
+		for _, name := range []string{"user-1234.json", "user-1234-1.json", "latest.json"} {
+			b, err := os.ReadFile(path.Join(dir, "out", name))
+			if err != nil {
+				t.Error(err)
+				continue
+			}
+			if string(b) != `{"id":1234}` {
+				t.Errorf("unexpected content of %s: %q", name, b)
+			}
+		}
+		if _, err := os.Stat(path.Join(dir, "out", "latest-1.json")); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected latest.json to be overwritten, got %v", err)
+		}
+	})
+
+	t.Run("Responses of requests parsed from an fs.FS are saved relative to the working directory", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		}))
+		defer srv.Close()
+		wd, err := os.Getwd()
+		if err != nil {
+			t.Fatal(err)
+		}
+		dir := t.TempDir()
+		if err := os.Chdir(dir); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Chdir(wd)
+		fsys := fstest.MapFS{"api/users.http": {Data: []byte("GET {{host}}/users\n\n>> out/users.json\n")}}
+		requests, err := ParseFS(fsys, "api/users.http")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := requests[0].Do(WithEnvironment(context.Background(), map[string]string{"host": srv.URL})); err != nil {
+			t.Fatal(err)
+		}
+		if b, err := os.ReadFile(path.Join(dir, "out", "users.json")); err != nil || string(b) != "ok" {
+			t.Errorf("expected the response in the working directory, got %q, %v", b, err)
+		}
+	})
+
 	t.Run("The environment is updated by pre-request scripts and successfully executed", func(t *testing.T) {
 		request := Request{
 			Method: "GET",
@@ -395,7 +1174,7 @@ assert(request !== undefined, 'request is defined')`, srv.URL)
 		}, resp.PostRequestAssertions); diff != "" {
 			t.Errorf("assertions mismatch (-want +got):\n%s", diff)
 		}
-		env := GetEnvironment(ctx)
+		env := GetEnvironment(ctx).Strings()
 		if diff := cmp.Diff(map[string]string{
 			"host":    srv.URL,
 			"someVar": "someValue",
@@ -404,3 +1183,23 @@ assert(request !== undefined, 'request is defined')`, srv.URL)
 		}
 	})
 }
+
+// bodyRunner responds to all requests with body.
+type bodyRunner struct {
+	body *trackedBody
+}
+
+func (r bodyRunner) Do(req *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: r.body, Request: req}, nil
+}
+
+// trackedBody records whether it is closed.
+type trackedBody struct {
+	io.Reader
+	closed bool
+}
+
+func (b *trackedBody) Close() error {
+	b.closed = true
+	return nil
+}
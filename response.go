@@ -3,9 +3,13 @@ package rq
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
 )
 
@@ -19,16 +23,23 @@ type Response struct {
 	*http.Response
 
 	PostRequestAssertions []Assertion
+
+	// secrets are the secret values of the environment of the request, masked by String.
+	secrets []string
 }
 
 func (resp *Response) Raw() *http.Response {
 	return resp.Response
 }
 
+// String returns the response as sent by the server, with the secret values of the
+// environment of the request and the values of the Set-Cookie headers masked.
 func (resp *Response) String() string {
 	buf := bytes.NewBuffer(nil)
-	resp.Response.Write(buf)
-	payload := buf.String()
+	raw := *resp.Response
+	raw.Header = redactHeaders(resp.Header)
+	raw.Write(buf)
+	payload := redact(buf.String(), resp.secrets)
 	resp.Body = io.NopCloser(bytes.NewBufferString(payload))
 	return payload
 }
@@ -39,11 +50,11 @@ func (resp *Response) PrettyString() (string, error) {
 	fmt.Fprintf(builder, "%s %s\n", resp.Proto, resp.Status)
 	for key, values := range resp.Header {
 		for _, value := range values {
-			fmt.Fprintf(builder, "%s: %s\n", key, value)
+			fmt.Fprintf(builder, "%s: %s\n", key, RedactHeader(key, value))
 		}
 	}
 	if resp.ContentLength == 0 {
-		return builder.String(), nil
+		return redact(builder.String(), resp.secrets), nil
 	}
 	builder.WriteString("\n")
 	defer resp.Raw().Body.Close()
@@ -62,5 +73,41 @@ func (resp *Response) PrettyString() (string, error) {
 	default:
 		builder.WriteString(string(body))
 	}
-	return builder.String(), nil
+	return redact(builder.String(), resp.secrets), nil
+}
+
+// save writes the response body to the file name, creating its directory if needed.
+// Unless overwrite is set, a numeric suffix is added to the file name when the file
+// already exists, ex., user-1.json.
+func (resp *Response) save(name string, overwrite bool) error {
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewBuffer(b))
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !overwrite {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	}
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		file, err := os.OpenFile(name, flag, 0o644)
+		if errors.Is(err, fs.ErrExist) {
+			name = fmt.Sprintf("%s-%d%s", base, i, ext)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := file.Write(b); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+	}
 }
@@ -5,15 +5,22 @@ import (
 	"context"
 	"encoding/json"
 	"io"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/dop251/goja"
 )
 
 type Runtime struct {
 	vm          *goja.Runtime
-	environment map[string]string
+	environment *Environment
 	request     *Request
+	// state persists the variables set by scripts, see WithStateStore.
+	state *StateStore
+	// secrets holds the secrets resolved by the secret provider of the request, which
+	// are masked in the logs.
+	secrets *secretCache
 }
 
 type Assertion struct {
@@ -21,14 +28,6 @@ type Assertion struct {
 	Success bool   `json:"success"`
 }
 
-func (r *Runtime) extractEnvironment() {
-	value, err := r.vm.RunString(`environment`)
-	if err != nil {
-		panic(err)
-	}
-	r.environment = value.Export().(map[string]string)
-}
-
 func (r *Runtime) extractAssertions() []Assertion {
 	value, err := r.vm.RunString(`assertions`)
 	if err != nil {
@@ -37,17 +36,27 @@ func (r *Runtime) extractAssertions() []Assertion {
 	return value.Export().([]Assertion)
 }
 
+// extractLogs returns the logs of the scripts, with the secret values of the
+// environment and of the secret provider masked.
 func (r *Runtime) extractLogs() []string {
 	value, err := r.vm.RunString(`logs`)
 	if err != nil {
 		panic(err)
 	}
-	return value.Export().([]string)
+	logs := value.Export().([]string)
+	if secrets := secretsOf(r.environment, r.secrets); len(secrets) > 0 {
+		for i, log := range logs {
+			logs[i] = redact(log, secrets)
+		}
+	}
+	return logs
 }
 
 func (r *Runtime) reset() {
 	r.request = nil
-	r.vm.Set("environment", r.environment)
+	r.state = nil
+	r.secrets = nil
+	r.vm.Set("environment", r.vm.NewDynamicObject(scriptEnvironment{r}))
 	r.vm.Set("request", nil)
 	r.vm.Set("response", nil)
 	r.resetLogs()
@@ -70,9 +79,44 @@ func (r *Runtime) setRequest(req *Request) {
 		"headers": req.Headers,
 		"method":  req.Method,
 		"url":     req.URL,
+		"parts":   scriptParts(req.Parts),
 	})
 }
 
+// setStateStore persists the variables set by scripts to s, the variables of s that
+// are not set in the environment are added to it.
+func (r *Runtime) setStateStore(s *StateStore) {
+	r.state = s
+	if s == nil {
+		return
+	}
+	for key, value := range s.Values() {
+		if _, ok := r.environment.Get(key); ok {
+			continue
+		}
+		if s.IsSecret(key) {
+			r.environment.SetSecret(key, value)
+		} else {
+			r.environment.Set(key, value)
+		}
+	}
+}
+
+// scriptParts converts the parts of a multipart body to the objects exposed to scripts.
+func scriptParts(parts []Part) []map[string]any {
+	result := make([]map[string]any, len(parts))
+	for i, part := range parts {
+		result[i] = map[string]any{
+			"name":     part.Name(),
+			"filename": part.FileName(),
+			"file":     part.File,
+			"headers":  part.Headers,
+			"body":     part.Body,
+		}
+	}
+	return result
+}
+
 func (r *Runtime) executeScript(script string) error {
 	_, err := r.vm.RunString(script)
 	req := r.extractRequest()
@@ -115,16 +159,118 @@ func (r *Runtime) setResponse(resp *Response) {
 	r.vm.Set("response", respData)
 }
 
+// scriptEnvironment exposes the environment to scripts as the `environment` object,
+// the values set by scripts are exported to Go values.
+type scriptEnvironment struct {
+	rt *Runtime
+}
+
+func (e scriptEnvironment) Get(key string) goja.Value {
+	if value, ok := e.rt.environment.Get(key); ok {
+		return e.rt.vm.ToValue(value)
+	}
+	return nil
+}
+
+func (e scriptEnvironment) Set(key string, value goja.Value) bool {
+	e.rt.setEnv(key, value, nil)
+	return true
+}
+
+func (e scriptEnvironment) Has(key string) bool {
+	_, ok := e.rt.environment.Get(key)
+	return ok
+}
+
+func (e scriptEnvironment) Delete(key string) bool {
+	e.rt.environment.Delete(key)
+	if e.rt.state != nil {
+		if err := e.rt.state.Delete(key); err != nil {
+			panic(e.rt.vm.NewGoError(err))
+		}
+	}
+	return true
+}
+
+func (e scriptEnvironment) Keys() []string {
+	var keys []string
+	for key := range e.rt.environment.Values() {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// setEnv sets the variable key of the environment and persists it to the state store,
+// where it expires after ttl, given in seconds or as a duration such as "1h".
+func (r *Runtime) setEnv(key string, value goja.Value, ttl goja.Value) {
+	r.set("setEnv", key, value, ttl, false)
+}
+
+// setSecret sets the variable key of the environment to a secret value, see setEnv.
+func (r *Runtime) setSecret(key string, value goja.Value, ttl goja.Value) {
+	r.set("setSecret", key, value, ttl, true)
+}
+
+// set implements the fn script function.
+func (r *Runtime) set(fn string, key string, value goja.Value, ttl goja.Value, secret bool) {
+	lifetime := r.ttl(fn, ttl)
+	// the values of missing arguments are nil
+	var exported any
+	if value != nil {
+		exported = value.Export()
+	}
+	if secret {
+		r.environment.SetSecret(key, exported)
+	} else {
+		r.environment.Set(key, exported)
+	}
+	if r.state == nil {
+		return
+	}
+	save := r.state.Set
+	if r.environment.IsSecret(key) {
+		save = r.state.SetSecret
+	}
+	if err := save(key, exported, lifetime); err != nil {
+		panic(r.vm.NewGoError(err))
+	}
+}
+
+// ttl converts the ttl argument of the fn script function, given in seconds or as a
+// duration such as "1h", it is zero when the argument is missing.
+func (r *Runtime) ttl(fn string, ttl goja.Value) time.Duration {
+	if ttl == nil {
+		return 0
+	}
+	var lifetime time.Duration
+	switch exported := ttl.Export().(type) {
+	case nil:
+	case int64:
+		lifetime = time.Duration(exported) * time.Second
+	case float64:
+		lifetime = time.Duration(exported * float64(time.Second))
+	case string:
+		d, err := time.ParseDuration(exported)
+		if err != nil {
+			panic(r.vm.NewTypeError("%s: invalid ttl %q", fn, exported))
+		}
+		lifetime = d
+	default:
+		panic(r.vm.NewTypeError("%s: invalid ttl %v", fn, exported))
+	}
+	if lifetime < 0 {
+		panic(r.vm.NewTypeError("%s: invalid ttl %v, it must not be negative", fn, ttl))
+	}
+	return lifetime
+}
+
 // scripts are javascript scripts that are loaded into each runtime instance.
 var scripts = []string{
 	`function assert(condition, message) {
   assertions.push({ Message: message, Success: condition })
 }`,
 
-	`function setEnv(key, value) {
-  environment[key] = value
-}`,
-
 	`function getEnv(key) {
   return environment[key]
 }`,
@@ -137,7 +283,7 @@ var scripts = []string{
 type runtimeContextKey struct{}
 
 func WithRuntime(ctx context.Context, rt *Runtime) context.Context {
-	return WithEnvironment(context.WithValue(ctx, runtimeContextKey{}, rt), rt.environment)
+	return WithEnvironmentScope(context.WithValue(ctx, runtimeContextKey{}, rt), rt.environment)
 }
 
 func getRuntime(ctx context.Context) *Runtime {
@@ -149,6 +295,8 @@ func getRuntime(ctx context.Context) *Runtime {
 		vm:          goja.New(),
 		environment: GetEnvironment(ctx),
 	}
+	rt.vm.Set("setEnv", rt.setEnv)
+	rt.vm.Set("setSecret", rt.setSecret)
 	for _, script := range scripts {
 		_, err := rt.vm.RunString(script)
 		if err != nil {
@@ -0,0 +1,174 @@
+package rq
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ErrSecretNotFound is returned by secret providers for secrets that do not exist.
+var ErrSecretNotFound = errors.New("secret not found")
+
+// SecretProvider resolves the `{{$secret path/to/key}}` templates, ex., from a vault.
+// The resolved values are secret, see Redact.
+type SecretProvider interface {
+	Secret(ctx context.Context, path string) (string, error)
+}
+
+// SecretProviderFunc is a function implementing SecretProvider.
+type SecretProviderFunc func(ctx context.Context, path string) (string, error)
+
+func (f SecretProviderFunc) Secret(ctx context.Context, path string) (string, error) {
+	return f(ctx, path)
+}
+
+// MapSecretProvider is a SecretProvider holding its secrets in memory, ex., to stand
+// in for a vault in tests.
+type MapSecretProvider map[string]string
+
+func (p MapSecretProvider) Secret(_ context.Context, path string) (string, error) {
+	if value, ok := p[path]; ok {
+		return value, nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrSecretNotFound, path)
+}
+
+// FileSecretProvider reads the secrets from the files of dir, the path of a secret is
+// the slash-separated path of its file relative to dir, ex., `db/password`. Trailing
+// newlines are removed from the values.
+func FileSecretProvider(dir string) SecretProvider {
+	return SecretProviderFunc(func(_ context.Context, path string) (string, error) {
+		if !fs.ValidPath(path) {
+			return "", fmt.Errorf("invalid secret path %s", path)
+		}
+		b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(path)))
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("%w: %s", ErrSecretNotFound, path)
+		}
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	})
+}
+
+// CommandSecretProvider runs the command name with args followed by the path of the
+// secret, and reads the secret from its standard output, ex.,
+// CommandSecretProvider("pass", "show"). Trailing newlines are removed from the values.
+// Paths starting with `-` are rejected so that they are not taken for flags.
+func CommandSecretProvider(name string, args ...string) SecretProvider {
+	return SecretProviderFunc(func(ctx context.Context, path string) (string, error) {
+		if strings.HasPrefix(path, "-") {
+			return "", fmt.Errorf("invalid secret path %s", path)
+		}
+		var stdout, stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, name, append(args[:len(args):len(args)], path)...)
+		cmd.Stdout, cmd.Stderr = &stdout, &stderr
+		if err := cmd.Run(); err != nil {
+			if message := strings.TrimSpace(stderr.String()); message != "" {
+				return "", fmt.Errorf("%s: %w: %s", name, err, message)
+			}
+			return "", fmt.Errorf("%s: %w", name, err)
+		}
+		return strings.TrimRight(stdout.String(), "\r\n"), nil
+	})
+}
+
+// secretCache caches the secrets resolved by a provider.
+type secretCache struct {
+	provider SecretProvider
+
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func (c *secretCache) secret(ctx context.Context, path string) (string, error) {
+	c.mu.Lock()
+	value, ok := c.values[path]
+	c.mu.Unlock()
+	if ok {
+		return value, nil
+	}
+	value, err := c.provider.Secret(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[path] = value
+	return value, nil
+}
+
+// secrets returns the resolved secrets.
+func (c *secretCache) secrets() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	secrets := make([]string, 0, len(c.values))
+	for _, value := range c.values {
+		if value != "" {
+			secrets = append(secrets, value)
+		}
+	}
+	return secrets
+}
+
+type secretProviderContextKey struct{}
+
+// WithSecretProvider resolves the `{{$secret path}}` templates of the requests run with
+// the context with provider. The secrets are cached for all the requests run with the
+// context, so that a run resolves each secret once.
+func WithSecretProvider(ctx context.Context, provider SecretProvider) context.Context {
+	return context.WithValue(ctx, secretProviderContextKey{}, &secretCache{provider: provider, values: map[string]string{}})
+}
+
+func getSecretCache(ctx context.Context) *secretCache {
+	cache, _ := ctx.Value(secretProviderContextKey{}).(*secretCache)
+	return cache
+}
+
+// secretFunc resolves the secret given as argument with the provider of the context.
+func secretFunc(tc TemplateContext, args ...string) (string, error) {
+	if err := expectArgs(args, 1, 1); err != nil {
+		return "", err
+	}
+	cache := getSecretCache(tc)
+	if cache == nil {
+		return "", errors.New("no secret provider, see WithSecretProvider")
+	}
+	return cache.secret(tc, args[0])
+}
+
+// contextSecrets returns the secret values of the environment, of the state store and
+// of the secret provider of the context, longest first.
+func contextSecrets(ctx context.Context) []string {
+	secrets := secretsOf(GetEnvironment(ctx), getSecretCache(ctx))
+	if s := getStateStore(ctx); s != nil {
+		secrets = append(secrets, s.secrets()...)
+		sortSecrets(secrets)
+	}
+	return secrets
+}
+
+// secretsOf returns the secret values of env and of cache, which may be nil, longest
+// first.
+func secretsOf(env *Environment, cache *secretCache) []string {
+	secrets := env.Secrets()
+	if cache != nil {
+		secrets = append(secrets, cache.secrets()...)
+		sortSecrets(secrets)
+	}
+	return secrets
+}
+
+// Redact masks the secret values of the environment, of the state store and of the
+// secret provider of the context in text.
+func Redact(ctx context.Context, text string) string {
+	return redact(text, contextSecrets(ctx))
+}
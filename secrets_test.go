@@ -0,0 +1,162 @@
+package rq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSecretProvider(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Api-Key")))
+	}))
+	defer srv.Close()
+	env := map[string]string{"host": srv.URL}
+
+	t.Run("Secrets are resolved once per context", func(t *testing.T) {
+		var calls atomic.Int32
+		secrets := MapSecretProvider{"api/key": "k-123"}
+		provider := SecretProviderFunc(func(ctx context.Context, path string) (string, error) {
+			calls.Add(1)
+			return secrets.Secret(ctx, path)
+		})
+		request := Request{
+			Method:  "GET",
+			URL:     "{{host}}/users",
+			Headers: Headers{{Key: "X-Api-Key", Value: "{{$secret api/key}}"}},
+		}
+		ctx := WithSecretProvider(WithEnvironment(context.Background(), env), provider)
+		for i := 0; i < 2; i++ {
+			resp, err := request.Do(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if body, _ := io.ReadAll(resp.Body); string(body) != "k-123" {
+				t.Errorf("expected the secret to be sent, got %q", body)
+			}
+		}
+		if calls.Load() != 1 {
+			t.Errorf("expected the provider to be called once, got %d calls", calls.Load())
+		}
+	})
+
+	t.Run("Resolved secrets are masked", func(t *testing.T) {
+		request := Request{
+			Method:  "GET",
+			URL:     "{{host}}/users?key={{$secret api/key}}",
+			Headers: Headers{{Key: "X-Api-Key", Value: "{{$secret api/key}}"}},
+		}
+		ctx := WithSecretProvider(WithEnvironment(context.Background(), env), MapSecretProvider{"api/key": "k-123"})
+		applied := applyEnv(t, ctx, request)
+		expected := "GET " + srv.URL + "/users?key=****\nX-Api-Key: ****\n"
+		if diff := cmp.Diff(expected, applied.String()); diff != "" {
+			t.Errorf("request mismatch (-want +got):\n%s", diff)
+		}
+		if diff := cmp.Diff("key ****", Redact(ctx, "key k-123")); diff != "" {
+			t.Errorf("redacted text mismatch (-want +got):\n%s", diff)
+		}
+		resp, err := request.Do(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output := resp.String(); strings.Contains(output, "k-123") {
+			t.Errorf("expected the secret to be masked, got:\n%s", output)
+		}
+	})
+
+	t.Run("Resolved secrets are masked in the logs of scripts", func(t *testing.T) {
+		request := Request{
+			Method:            "GET",
+			URL:               "{{host}}/users",
+			Headers:           Headers{{Key: "X-Api-Key", Value: "{{$secret db/pw}}"}},
+			PostRequestScript: `log('body=' + response.body)`,
+		}
+		logger := &testLogger{}
+		ctx := WithSecretProvider(WithEnvironment(context.Background(), env), MapSecretProvider{"db/pw": "hunter2"})
+		if _, err := request.Do(WithLogger(ctx, logger)); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]string{"body=****"}, request.Logs); diff != "" {
+			t.Errorf("logs mismatch (-want +got):\n%s", diff)
+		}
+		if diff := cmp.Diff([]string{"body=****"}, logger.logs); diff != "" {
+			t.Errorf("logger mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Errors are reported", func(t *testing.T) {
+		request := Request{Method: "GET", URL: "{{host}}/users?key={{$secret api/key}}"}
+		ctx := WithEnvironment(context.Background(), env)
+		if _, err := request.ApplyEnv(WithStrictVariables(ctx)); err == nil || !strings.Contains(err.Error(), "no secret provider") {
+			t.Errorf("expected a missing provider error, got %v", err)
+		}
+		_, err := request.ApplyEnv(WithStrictVariables(WithSecretProvider(ctx, MapSecretProvider{})))
+		if !errors.Is(err, ErrSecretNotFound) {
+			t.Errorf("expected a secret not found error, got %v", err)
+		}
+	})
+
+	t.Run("Secrets are read from files", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(dir, "db"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "db", "password"), []byte("hunter2\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		provider := FileSecretProvider(dir)
+		value, err := provider.Secret(context.Background(), "db/password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff("hunter2", value); diff != "" {
+			t.Errorf("secret mismatch (-want +got):\n%s", diff)
+		}
+		if _, err := provider.Secret(context.Background(), "db/user"); !errors.Is(err, ErrSecretNotFound) {
+			t.Errorf("expected a secret not found error, got %v", err)
+		}
+		if _, err := provider.Secret(context.Background(), "../password"); err == nil {
+			t.Error("expected paths outside of the directory to be rejected")
+		}
+	})
+
+	t.Run("Secrets are read from the output of commands", func(t *testing.T) {
+		if _, err := os.Stat("/bin/sh"); err != nil {
+			t.Skip("requires /bin/sh")
+		}
+		provider := CommandSecretProvider("/bin/sh", "-c", `test "$0" = api/key && echo k-123 || { echo "unknown secret $0" >&2; exit 1; }`)
+		value, err := provider.Secret(context.Background(), "api/key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff("k-123", value); diff != "" {
+			t.Errorf("secret mismatch (-want +got):\n%s", diff)
+		}
+		if _, err := provider.Secret(context.Background(), "db/password"); err == nil || !strings.Contains(err.Error(), "unknown secret db/password") {
+			t.Errorf("expected the error output of the command, got %v", err)
+		}
+		for _, path := range []string{"--help", "-c"} {
+			if _, err := provider.Secret(context.Background(), path); err == nil || err.Error() != "invalid secret path "+path {
+				t.Errorf("expected %s to be rejected, got %v", path, err)
+			}
+		}
+	})
+}
+
+type testLogger struct {
+	logs []string
+}
+
+func (l *testLogger) Log(args ...any) {
+	l.logs = append(l.logs, fmt.Sprint(args...))
+}
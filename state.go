@@ -0,0 +1,210 @@
+package rq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// StateFile is the conventional path of the state file of a collection, relative to
+// the directory of its .http files.
+const StateFile = ".rq/state.json"
+
+// StateStore persists the variables set by scripts to a file, so that they are available
+// to the next runs, ex., the token of a login request. Variables may expire, they are
+// then dropped when the file is loaded. Secret variables are only kept in memory unless
+// WithPersistedSecrets is given. A StateStore is safe for concurrent use.
+type StateStore struct {
+	path string
+	now  func() time.Time
+	// persistSecrets writes the secret variables to the file
+	persistSecrets bool
+
+	mu      sync.Mutex
+	entries map[string]stateEntry
+}
+
+// stateEntry is a variable of the state file.
+type stateEntry struct {
+	Value   any        `json:"value"`
+	Expires *time.Time `json:"expires,omitempty"`
+	Secret  bool       `json:"secret,omitempty"`
+}
+
+// StateStoreOption configures a StateStore.
+type StateStoreOption func(*StateStore)
+
+// WithPersistedSecrets writes the secret variables to the state file, in plain text, so
+// that they are available to the next runs as well.
+func WithPersistedSecrets() StateStoreOption {
+	return func(s *StateStore) {
+		s.persistSecrets = true
+	}
+}
+
+// OpenStateStore loads the state file at path, a missing file holds no variables and
+// is created by the first change.
+func OpenStateStore(path string, options ...StateStoreOption) (*StateStore, error) {
+	s := &StateStore{path: path, now: time.Now, entries: map[string]stateEntry{}}
+	for _, option := range options {
+		option(s)
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.entries); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	now := s.now()
+	for key, entry := range s.entries {
+		if entry.expired(now) {
+			delete(s.entries, key)
+		}
+	}
+	return s, nil
+}
+
+func (e stateEntry) expired(now time.Time) bool {
+	return e.Expires != nil && !now.Before(*e.Expires)
+}
+
+// Values returns the variables of the store that have not expired.
+func (s *StateStore) Values() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	values := make(map[string]any, len(s.entries))
+	for key, entry := range s.entries {
+		if !entry.expired(now) {
+			values[key] = entry.Value
+		}
+	}
+	return values
+}
+
+// secrets returns the secret values of the store that have not expired.
+func (s *StateStore) secrets() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	var secrets []string
+	for _, entry := range s.entries {
+		if value := formatValue(entry.Value); entry.Secret && !entry.expired(now) && value != "" {
+			secrets = append(secrets, value)
+		}
+	}
+	return secrets
+}
+
+// IsSecret reports whether the variable key of the store is secret.
+func (s *StateStore) IsSecret(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.entries[key].Secret
+}
+
+// Set persists the variable key, it expires after ttl unless ttl is zero. Negative
+// ttls are invalid.
+func (s *StateStore) Set(key string, value any, ttl time.Duration) error {
+	return s.set(key, stateEntry{Value: value}, ttl)
+}
+
+// SetSecret persists the variable key with a secret value, see Set.
+func (s *StateStore) SetSecret(key string, value any, ttl time.Duration) error {
+	return s.set(key, stateEntry{Value: value, Secret: true}, ttl)
+}
+
+func (s *StateStore) set(key string, entry stateEntry, ttl time.Duration) error {
+	if ttl < 0 {
+		return fmt.Errorf("invalid ttl %s", ttl)
+	}
+	if ttl > 0 {
+		expires := s.now().Add(ttl).UTC()
+		entry.Expires = &expires
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = entry
+	return s.save()
+}
+
+// Delete removes the variable key from the store.
+func (s *StateStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[key]; !ok {
+		return nil
+	}
+	delete(s.entries, key)
+	return s.save()
+}
+
+// save writes the state file, replacing it atomically. The secret variables are left
+// out unless they are persisted.
+func (s *StateStore) save() error {
+	entries := make(map[string]stateEntry, len(s.entries))
+	for key, entry := range s.entries {
+		if !entry.Secret || s.persistSecrets {
+			entries[key] = entry
+		}
+	}
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(b, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+type stateStoreContextKey struct{}
+
+// WithStateStore persists the variables set by the scripts of the requests run with the
+// context to s, and makes the variables of s available to their scripts and templates,
+// including ApplyEnv and Variable, when they are not set in the environment of the
+// context.
+func WithStateStore(ctx context.Context, s *StateStore) context.Context {
+	return context.WithValue(ctx, stateStoreContextKey{}, s)
+}
+
+func getStateStore(ctx context.Context) *StateStore {
+	s, _ := ctx.Value(stateStoreContextKey{}).(*StateStore)
+	return s
+}
+
+// contextVariables returns the string form of the variables of the environment of the
+// context and of its state store, whose variables do not override the environment.
+func contextVariables(ctx context.Context) map[string]string {
+	variables := GetEnvironment(ctx).Strings()
+	if s := getStateStore(ctx); s != nil {
+		for key, value := range s.Values() {
+			if _, ok := variables[key]; !ok {
+				variables[key] = formatValue(value)
+			}
+		}
+	}
+	return variables
+}
@@ -0,0 +1,228 @@
+package rq
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStateStore(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer srv.Close()
+	env := map[string]string{"host": srv.URL}
+
+	t.Run("Variables set by scripts are persisted", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), StateFile)
+		store, err := OpenStateStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		login := Request{
+			Method: "POST",
+			URL:    "{{host}}/login",
+			PostRequestScript: `setEnv('token', 'abc', '1h');
+setEnv('user', {id: 1});
+environment.session = 's1';
+setEnv('temporary', 'x');
+delete environment.temporary;`,
+		}
+		if _, err := login.Do(WithStateStore(WithEnvironment(context.Background(), env), store)); err != nil {
+			t.Fatal(err)
+		}
+
+		store, err = OpenStateStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(map[string]any{
+			"token":   "abc",
+			"user":    map[string]any{"id": float64(1)},
+			"session": "s1",
+		}, store.Values()); diff != "" {
+			t.Errorf("values mismatch (-want +got):\n%s", diff)
+		}
+
+		request := Request{
+			Method:  "GET",
+			URL:     "{{host}}/users/{{user}}",
+			Headers: Headers{{Key: "Authorization", Value: "Bearer {{token}}"}},
+		}
+		resp, err := request.Do(WithStateStore(WithEnvironment(context.Background(), env), store))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if diff := cmp.Diff("Bearer abc", string(body)); diff != "" {
+			t.Errorf("body mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Secret variables stay secret", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), StateFile)
+		store, err := OpenStateStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		login := Request{Method: "POST", URL: "{{host}}/login", PostRequestScript: `setSecret('token', 'abc', '1h')`}
+		if _, err := login.Do(WithStateStore(WithEnvironment(context.Background(), env), store)); err != nil {
+			t.Fatal(err)
+		}
+		if !store.IsSecret("token") {
+			t.Error("expected the token to be secret")
+		}
+		if b, err := os.ReadFile(path); err != nil || strings.Contains(string(b), "abc") {
+			t.Errorf("expected the token not to be written, got %q (%v)", b, err)
+		}
+		request := Request{Method: "GET", URL: "{{host}}", Headers: Headers{{Key: "Authorization", Value: "{{token}}"}}}
+		ctx := WithStateStore(WithEnvironment(context.Background(), env), store)
+		resp, err := request.Do(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output := resp.String(); strings.Contains(output, "abc") {
+			t.Errorf("expected the token to be masked, got:\n%s", output)
+		}
+	})
+
+	t.Run("Secret variables are persisted on demand", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), StateFile)
+		store, err := OpenStateStore(path, WithPersistedSecrets())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.SetSecret("token", "abc", time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if store, err = OpenStateStore(path); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(map[string]any{"token": "abc"}, store.Values()); diff != "" {
+			t.Errorf("values mismatch (-want +got):\n%s", diff)
+		}
+		if !store.IsSecret("token") {
+			t.Error("expected the token to be persisted as secret")
+		}
+	})
+
+	t.Run("Variables are available to ApplyEnv", func(t *testing.T) {
+		store, err := OpenStateStore(filepath.Join(t.TempDir(), StateFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Set("user", map[string]any{"id": 1}, 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.SetSecret("token", "abc", time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		request := Request{Method: "GET", URL: "{{host}}/users?filter={{user}}", Headers: Headers{{Key: "X-Token", Value: "{{token}}"}}}
+		ctx := WithStateStore(WithEnvironment(context.Background(), env), store)
+		applied, err := request.ApplyEnv(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff("abc", applied.Headers.Get("X-Token")); diff != "" {
+			t.Errorf("header mismatch (-want +got):\n%s", diff)
+		}
+		if diff := cmp.Diff("X-Token: ****", strings.Split(applied.String(), "\n")[1]); diff != "" {
+			t.Errorf("request mismatch (-want +got):\n%s", diff)
+		}
+		if value, ok := request.Variable(ctx, "user"); !ok || value != `{"id":1}` {
+			t.Errorf("expected the stored user, got %q", value)
+		}
+	})
+
+	t.Run("The environment of the context takes precedence", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), StateFile)
+		store, err := OpenStateStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Set("token", "stored", 0); err != nil {
+			t.Fatal(err)
+		}
+		request := Request{Method: "GET", URL: "{{host}}", Headers: Headers{{Key: "Authorization", Value: "{{token}}"}}}
+		ctx := WithEnvironment(context.Background(), map[string]string{"host": srv.URL, "token": "given"})
+		resp, err := request.Do(WithStateStore(ctx, store))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body, _ := io.ReadAll(resp.Body); string(body) != "given" {
+			t.Errorf("expected the token of the context, got %q", body)
+		}
+	})
+
+	t.Run("Expired variables are dropped", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), StateFile)
+		store, err := OpenStateStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		now := time.Now()
+		store.now = func() time.Time { return now.Add(-2 * time.Hour) }
+		if err := store.Set("expired", "a", time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		store.now = func() time.Time { return now }
+		if err := store.Set("valid", "b", time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Set("forever", "c", 0); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(map[string]any{"valid": "b", "forever": "c"}, store.Values()); diff != "" {
+			t.Errorf("values mismatch (-want +got):\n%s", diff)
+		}
+		store, err = OpenStateStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := store.entries["expired"]; ok {
+			t.Error("expected the expired variable to be dropped when the file is loaded")
+		}
+	})
+
+	t.Run("Invalid lifetimes are reported", func(t *testing.T) {
+		store, err := OpenStateStore(filepath.Join(t.TempDir(), StateFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for script, expected := range map[string]string{
+			"setEnv('token', 'abc', 'soon')":   `setEnv: invalid ttl "soon"`,
+			"setEnv('token', 'abc', -5)":       "setEnv: invalid ttl -5, it must not be negative",
+			"setSecret('token', 'abc', '-1h')": "setSecret: invalid ttl -1h, it must not be negative",
+		} {
+			request := Request{Method: "GET", URL: "{{host}}", PostRequestScript: script}
+			_, err = request.Do(WithStateStore(WithEnvironment(context.Background(), env), store))
+			if err == nil || !strings.Contains(err.Error(), expected) {
+				t.Errorf("%s: expected an invalid ttl error, got %v", script, err)
+			}
+		}
+		if err := store.Set("token", "abc", -time.Second); err == nil {
+			t.Error("expected negative ttls to be rejected by the store")
+		}
+		if diff := cmp.Diff(map[string]any{}, store.Values()); diff != "" {
+			t.Errorf("values mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Invalid state files are reported", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "state.json")
+		if err := os.WriteFile(path, []byte("{"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := OpenStateStore(path); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
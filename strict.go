@@ -0,0 +1,122 @@
+package rq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrUnresolvedVariable is matched with errors.Is by the errors returned in strict mode
+// when {{variables}} cannot be resolved, see WithStrictVariables.
+var ErrUnresolvedVariable = errors.New("unresolved variable")
+
+// UnresolvedVariable is a {{variable}} that could not be resolved.
+type UnresolvedVariable struct {
+	// Name is the expression of the template, ex., `host` or `$processEnv TOKEN`.
+	Name string
+	// Location is the part of the request the variable appears in, ex., `URL` or
+	// `header value Authorization`.
+	Location string
+	// Err is the reason the variable could not be resolved.
+	Err error
+}
+
+func (v UnresolvedVariable) String() string {
+	if errors.Is(v.Err, errUndefined) {
+		return fmt.Sprintf("{{%s}} in %s", v.Name, v.Location)
+	}
+	return fmt.Sprintf("{{%s}} in %s (%s)", v.Name, v.Location, v.Err)
+}
+
+// UnresolvedVariablesError lists the variables of a request that could not be resolved
+// in strict mode.
+type UnresolvedVariablesError []UnresolvedVariable
+
+func (e UnresolvedVariablesError) Error() string {
+	variables := make([]string, len(e))
+	for i, variable := range e {
+		variables[i] = variable.String()
+	}
+	return fmt.Sprintf("unresolved variables: %s", strings.Join(variables, ", "))
+}
+
+func (e UnresolvedVariablesError) Is(target error) bool {
+	return target == ErrUnresolvedVariable
+}
+
+func (e UnresolvedVariablesError) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, variable := range e {
+		errs[i] = variable.Err
+	}
+	return errs
+}
+
+type strictContextKey struct{}
+
+// WithStrictVariables enables the strict mode, in which ApplyEnv and Do fail with an
+// UnresolvedVariablesError when {{variables}} cannot be resolved instead of sending
+// them as is.
+func WithStrictVariables(ctx context.Context) context.Context {
+	return context.WithValue(ctx, strictContextKey{}, true)
+}
+
+func isStrict(ctx context.Context) bool {
+	strict, _ := ctx.Value(strictContextKey{}).(bool)
+	return strict
+}
+
+// templater replaces the templates of the texts of a request and records the ones
+// that cannot be resolved.
+type templater struct {
+	lookup     lookupFunc
+	unresolved UnresolvedVariablesError
+}
+
+// replace replaces the templates of text, location describes where text appears in
+// the request. Unresolved templates are not recorded when location is empty.
+func (t *templater) replace(text, location string) string {
+	return replaceVariables(text, func(expr string) (string, error) {
+		value, err := t.lookup(expr)
+		if err != nil && location != "" {
+			variable := UnresolvedVariable{Name: expr, Location: location, Err: err}
+			for _, v := range t.unresolved {
+				if v.Name == variable.Name && v.Location == variable.Location {
+					return value, err
+				}
+			}
+			t.unresolved = append(t.unresolved, variable)
+		}
+		return value, err
+	})
+}
+
+func (t *templater) headers(headers Headers, location string) Headers {
+	var result Headers
+	for _, header := range headers {
+		result = append(result, Header{
+			Key:   t.replace(header.Key, location+" name"),
+			Value: t.replace(header.Value, fmt.Sprintf("%s value %s", location, header.Key)),
+		})
+	}
+	return result
+}
+
+// err returns the unresolved variables in strict mode, and otherwise the references to
+// responses that could not be resolved, sending the request without them is pointless.
+func (t *templater) err(ctx context.Context) error {
+	unresolved := t.unresolved
+	if !isStrict(ctx) {
+		unresolved = nil
+		for _, variable := range t.unresolved {
+			if errors.Is(variable.Err, ErrResponseNotFound) {
+				unresolved = append(unresolved, variable)
+			}
+		}
+	}
+	if len(unresolved) == 0 {
+		return nil
+	}
+	return unresolved
+}
@@ -0,0 +1,76 @@
+package rq
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestWithStrictVariables(t *testing.T) {
+	request := Request{
+		Method: "POST",
+		URL:    "{{host}}/users/{{id}}?at={{$randomInt a}}",
+		Headers: Headers{
+			{Key: "Authorization", Value: "Bearer {{token}}"},
+			{Key: "X-{{header}}", Value: "{{id}}"},
+		},
+		Body:      `{"name": "{{name}}", "id": "{{id}}"}`,
+		Variables: map[string]string{"name": "Fred"},
+	}
+	ctx := WithEnvironment(context.Background(), map[string]string{"id": "1"})
+
+	t.Run("Unresolved variables are kept by default", func(t *testing.T) {
+		applied, err := request.ApplyEnv(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if applied.URL != "{{host}}/users/1?at={{$randomInt a}}" {
+			t.Errorf("unexpected url %q", applied.URL)
+		}
+	})
+
+	t.Run("Unresolved variables are reported in strict mode", func(t *testing.T) {
+		_, err := request.ApplyEnv(WithStrictVariables(ctx))
+		if !errors.Is(err, ErrUnresolvedVariable) {
+			t.Fatalf("expected an unresolved variable error, got %v", err)
+		}
+		var unresolved UnresolvedVariablesError
+		if !errors.As(err, &unresolved) {
+			t.Fatalf("expected an UnresolvedVariablesError, got %T", err)
+		}
+		if diff := cmp.Diff(UnresolvedVariablesError{
+			{Name: "host", Location: "URL", Err: errUndefined},
+			{Name: "$randomInt a", Location: "URL"},
+			{Name: "token", Location: "header value Authorization", Err: errUndefined},
+			{Name: "header", Location: "header name", Err: errUndefined},
+		}, unresolved, cmpopts.IgnoreFields(UnresolvedVariable{}, "Err")); diff != "" {
+			t.Errorf("unresolved variables mismatch (-want +got):\n%s", diff)
+		}
+		expected := `unresolved variables: {{host}} in URL, {{$randomInt a}} in URL ($randomInt: invalid bound "a"), ` +
+			`{{token}} in header value Authorization, {{header}} in header name`
+		if diff := cmp.Diff(expected, err.Error()); diff != "" {
+			t.Errorf("message mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Requests with unresolved variables are not sent in strict mode", func(t *testing.T) {
+		var called bool
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+		}))
+		defer srv.Close()
+		request := Request{Method: "GET", URL: srv.URL + "/{{missing}}", Body: "{{body}}"}
+		_, err := request.Do(WithStrictVariables(context.Background()))
+		if diff := cmp.Diff("unresolved variables: {{missing}} in URL, {{body}} in body", err.Error()); diff != "" {
+			t.Errorf("message mismatch (-want +got):\n%s", diff)
+		}
+		if called {
+			t.Error("expected the request not to be sent")
+		}
+	})
+}
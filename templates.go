@@ -0,0 +1,283 @@
+package rq
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TemplateFunc generates the value of a `{{$name args...}}` template, args are the
+// space separated arguments following the name, with quoted arguments unquoted.
+type TemplateFunc func(tc TemplateContext, args ...string) (string, error)
+
+// TemplateContext is passed to template functions.
+type TemplateContext struct {
+	context.Context
+	// Rand is the source of random values, seeded with WithSeed. It is shared by the
+	// requests run concurrently, its methods are safe to call except Read.
+	Rand *rand.Rand
+	// Now is the current time, fixed with WithNow. It is the same for all the
+	// templates of a request.
+	Now time.Time
+	// File is the path of the .http file of the request, empty when it was not parsed
+	// from a file.
+	File string
+}
+
+var (
+	templateFuncsMu sync.RWMutex
+	templateFuncs   = map[string]TemplateFunc{
+		"uuid":          uuidFunc,
+		"timestamp":     timestampFunc,
+		"isoTimestamp":  isoTimestampFunc,
+		"randomInt":     randomIntFunc,
+		"datetime":      datetimeFunc(time.UTC),
+		"localDatetime": datetimeFunc(time.Local),
+		"processEnv":    processEnvFunc,
+		"dotenv":        dotEnvFunc,
+		"secret":        secretFunc,
+	}
+)
+
+// RegisterTemplateFunc registers the function generating the `{{$name}}` templates,
+// replacing any function with the same name, including built-in ones.
+func RegisterTemplateFunc(name string, fn TemplateFunc) {
+	templateFuncsMu.Lock()
+	defer templateFuncsMu.Unlock()
+	templateFuncs[strings.TrimPrefix(name, "$")] = fn
+}
+
+func templateFunc(name string) (TemplateFunc, bool) {
+	templateFuncsMu.RLock()
+	defer templateFuncsMu.RUnlock()
+	fn, ok := templateFuncs[name]
+	return fn, ok
+}
+
+type randContextKey struct{}
+
+type nowContextKey struct{}
+
+// lockedSource is a rand.Source safe for concurrent use. It does not make the Read
+// method of a rand.Rand safe, which buffers bytes in the rand.Rand itself.
+type lockedSource struct {
+	mu     sync.Mutex
+	source rand.Source
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.source.Int63()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.source.Seed(seed)
+}
+
+// WithSeed seeds the random values generated by templates, such as `{{$uuid}}`, so
+// that the requests run with the context get the same values from one run to the next.
+func WithSeed(ctx context.Context, seed int64) context.Context {
+	return context.WithValue(ctx, randContextKey{}, rand.New(&lockedSource{source: rand.NewSource(seed)}))
+}
+
+// WithNow fixes the time used by templates, such as `{{$timestamp}}`.
+func WithNow(ctx context.Context, now time.Time) context.Context {
+	return context.WithValue(ctx, nowContextKey{}, now)
+}
+
+var globalRand = rand.New(&lockedSource{source: rand.NewSource(time.Now().UnixNano())})
+
+func newTemplateContext(ctx context.Context, file string) TemplateContext {
+	tc := TemplateContext{Context: ctx, Rand: globalRand, Now: time.Now(), File: file}
+	if r, ok := ctx.Value(randContextKey{}).(*rand.Rand); ok {
+		tc.Rand = r
+	}
+	if now, ok := ctx.Value(nowContextKey{}).(time.Time); ok {
+		tc.Now = now
+	}
+	return tc
+}
+
+// callTemplateFunc evaluates a `$name args...` expression, it is false when the
+// function does not exist.
+func callTemplateFunc(tc TemplateContext, expr string) (string, bool, error) {
+	args, err := splitArgs(expr)
+	if err != nil {
+		return "", true, err
+	}
+	fn, ok := templateFunc(strings.TrimPrefix(args[0], "$"))
+	if !ok {
+		return "", false, nil
+	}
+	value, err := fn(tc, args[1:]...)
+	if err != nil {
+		return "", true, fmt.Errorf("%s: %w", args[0], err)
+	}
+	return value, true, nil
+}
+
+// splitArgs splits an expression on spaces, double-quoted arguments may contain
+// spaces and escape sequences.
+func splitArgs(expr string) ([]string, error) {
+	var args []string
+	for expr = strings.TrimSpace(expr); expr != ""; expr = strings.TrimSpace(expr) {
+		if expr[0] != '"' {
+			arg, rest, _ := strings.Cut(expr, " ")
+			args = append(args, arg)
+			expr = rest
+			continue
+		}
+		quoted, err := strconv.QuotedPrefix(expr)
+		if err != nil {
+			return nil, fmt.Errorf("unterminated string in %s", expr)
+		}
+		arg, _ := strconv.Unquote(quoted)
+		args = append(args, arg)
+		expr = expr[len(quoted):]
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+	return args, nil
+}
+
+func expectArgs(args []string, min, max int) error {
+	if len(args) < min || len(args) > max {
+		if min == max {
+			return fmt.Errorf("expected %d arguments, got %d", min, len(args))
+		}
+		return fmt.Errorf("expected %d to %d arguments, got %d", min, max, len(args))
+	}
+	return nil
+}
+
+// uuidFunc generates a version 4 UUID.
+func uuidFunc(tc TemplateContext, args ...string) (string, error) {
+	if err := expectArgs(args, 0, 0); err != nil {
+		return "", err
+	}
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], tc.Rand.Uint64())
+	binary.BigEndian.PutUint64(b[8:], tc.Rand.Uint64())
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// timestampFunc returns the Unix time in seconds.
+func timestampFunc(tc TemplateContext, args ...string) (string, error) {
+	if err := expectArgs(args, 0, 0); err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(tc.Now.Unix(), 10), nil
+}
+
+// isoTimestampFunc returns the UTC time in the ISO-8601 format.
+func isoTimestampFunc(tc TemplateContext, args ...string) (string, error) {
+	if err := expectArgs(args, 0, 0); err != nil {
+		return "", err
+	}
+	return tc.Now.UTC().Format(time.RFC3339), nil
+}
+
+// randomIntFunc returns a random integer in [min, max), [0, 1000) by default.
+func randomIntFunc(tc TemplateContext, args ...string) (string, error) {
+	if err := expectArgs(args, 0, 2); err != nil {
+		return "", err
+	}
+	bounds := []int{0, 1000}
+	for i, arg := range args {
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			return "", fmt.Errorf("invalid bound %q", arg)
+		}
+		bounds[i] = n
+	}
+	if len(args) == 1 {
+		// a single argument is the upper bound
+		bounds = []int{0, bounds[0]}
+	}
+	if bounds[1] <= bounds[0] {
+		return "", fmt.Errorf("empty range [%d, %d)", bounds[0], bounds[1])
+	}
+	return strconv.Itoa(bounds[0] + tc.Rand.Intn(bounds[1]-bounds[0])), nil
+}
+
+// datetimeUnits are the units of the offsets of datetime templates.
+var datetimeUnits = map[string]func(t time.Time, n int) time.Time{
+	"y":  func(t time.Time, n int) time.Time { return t.AddDate(n, 0, 0) },
+	"M":  func(t time.Time, n int) time.Time { return t.AddDate(0, n, 0) },
+	"w":  func(t time.Time, n int) time.Time { return t.AddDate(0, 0, 7*n) },
+	"d":  func(t time.Time, n int) time.Time { return t.AddDate(0, 0, n) },
+	"h":  func(t time.Time, n int) time.Time { return t.Add(time.Duration(n) * time.Hour) },
+	"m":  func(t time.Time, n int) time.Time { return t.Add(time.Duration(n) * time.Minute) },
+	"s":  func(t time.Time, n int) time.Time { return t.Add(time.Duration(n) * time.Second) },
+	"ms": func(t time.Time, n int) time.Time { return t.Add(time.Duration(n) * time.Millisecond) },
+}
+
+// datetimeFunc formats the time in the location, the arguments are the format, either
+// rfc1123, iso8601 or a Go layout such as "2006-01-02", and an optional offset such as
+// `-1 d`.
+func datetimeFunc(location *time.Location) TemplateFunc {
+	return func(tc TemplateContext, args ...string) (string, error) {
+		if len(args) != 1 && len(args) != 3 {
+			return "", fmt.Errorf("expected a format and an optional offset, got %d arguments", len(args))
+		}
+		t := tc.Now.In(location)
+		if len(args) == 3 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil {
+				return "", fmt.Errorf("invalid offset %q", args[1])
+			}
+			add, ok := datetimeUnits[args[2]]
+			if !ok {
+				return "", fmt.Errorf("invalid unit %q", args[2])
+			}
+			t = add(t, n)
+		}
+		switch args[0] {
+		case "rfc1123":
+			return t.Format(time.RFC1123), nil
+		case "iso8601":
+			return t.Format(time.RFC3339), nil
+		}
+		return t.Format(args[0]), nil
+	}
+}
+
+// processEnvFunc returns the environment variable of the process given as argument.
+func processEnvFunc(tc TemplateContext, args ...string) (string, error) {
+	if err := expectArgs(args, 1, 1); err != nil {
+		return "", err
+	}
+	value, ok := ProcessEnv().Lookup(args[0])
+	if !ok {
+		return "", fmt.Errorf("%s is not set", args[0])
+	}
+	return value, nil
+}
+
+// dotEnvFunc returns the variable given as argument of the .env file in the directory
+// of the request.
+func dotEnvFunc(tc TemplateContext, args ...string) (string, error) {
+	if err := expectArgs(args, 1, 1); err != nil {
+		return "", err
+	}
+	source, err := dotEnv(tc.File)
+	if err != nil {
+		return "", err
+	}
+	value, ok := source.Lookup(args[0])
+	if !ok {
+		return "", fmt.Errorf("%s is not set in %s", args[0], DotEnvFile)
+	}
+	return value, nil
+}
@@ -0,0 +1,101 @@
+package rq
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTemplateFuncs(t *testing.T) {
+	now := time.Date(2024, time.February, 29, 13, 4, 5, 0, time.UTC)
+	ctx := WithNow(context.Background(), now)
+
+	t.Run("Dynamic variables are replaced", func(t *testing.T) {
+		for _, test := range []struct {
+			template string
+			expected string
+		}{
+			{"{{$timestamp}}", "1709211845"},
+			{"{{$isoTimestamp}}", "2024-02-29T13:04:05Z"},
+			{`{{$datetime "2006-01-02"}}`, "2024-02-29"},
+			{`{{$datetime "2006-01-02" -1 d}}`, "2024-02-28"},
+			{`{{$datetime "2006-01-02 15:04" 2 h}}`, "2024-02-29 15:04"},
+			{`{{$datetime iso8601 1 y}}`, "2025-03-01T13:04:05Z"},
+			{`{{$datetime rfc1123}}`, "Thu, 29 Feb 2024 13:04:05 UTC"},
+			{"{{$randomInt 5 6}}", "5"},
+			{"{{$unknown}}", "{{$unknown}}"},
+			{"{{$randomInt 6 5}}", "{{$randomInt 6 5}}"},
+			{`{{$datetime "2006}}`, `{{$datetime "2006}}`},
+		} {
+			request := Request{Method: "GET", URL: test.template}
+			if got := applyEnv(t, ctx, request).URL; got != test.expected {
+				t.Errorf("%s: expected %q, got %q", test.template, test.expected, got)
+			}
+		}
+	})
+
+	t.Run("Random values are in range", func(t *testing.T) {
+		request := Request{Method: "GET", URL: "{{$randomInt 10 20}} {{$randomInt}} {{$uuid}}"}
+		uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+		for i := 0; i < 100; i++ {
+			var small, large int
+			var id string
+			if _, err := fmt.Sscanf(applyEnv(t, ctx, request).URL, "%d %d %s", &small, &large, &id); err != nil {
+				t.Fatal(err)
+			}
+			if small < 10 || small >= 20 || large < 0 || large >= 1000 || !uuid.MatchString(id) {
+				t.Fatalf("unexpected values %d, %d, %s", small, large, id)
+			}
+		}
+	})
+
+	t.Run("Values are generated for concurrent requests", func(t *testing.T) {
+		request := Request{Method: "GET", URL: "{{$uuid}}/{{$randomInt}}"}
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := request.ApplyEnv(context.Background()); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+	})
+
+	t.Run("Seeded values are deterministic", func(t *testing.T) {
+		request := Request{Method: "GET", URL: "{{$uuid}}/{{$randomInt}}", Variables: map[string]string{"id": "{{$uuid}}"}}
+		run := func() []string {
+			ctx := WithSeed(ctx, 42)
+			var urls []string
+			for i := 0; i < 3; i++ {
+				urls = append(urls, applyEnv(t, ctx, request).URL)
+			}
+			return urls
+		}
+		first, second := run(), run()
+		if diff := cmp.Diff(first, second); diff != "" {
+			t.Errorf("seeded values differ (-first +second):\n%s", diff)
+		}
+		if first[0] == first[1] {
+			t.Errorf("expected a new value for each request, got %v", first)
+		}
+	})
+
+	t.Run("Template functions can be registered", func(t *testing.T) {
+		RegisterTemplateFunc("$join", func(tc TemplateContext, args ...string) (string, error) {
+			return strings.Join(args, "-") + "@" + tc.Now.Format("2006"), nil
+		})
+		request := Request{Method: "GET", URL: `/{{$join a "b c" d}}`}
+		if diff := cmp.Diff("/a-b c-d@2024", applyEnv(t, ctx, request).URL); diff != "" {
+			t.Errorf("url mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
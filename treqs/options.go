@@ -1,7 +1,28 @@
 package treqs
 
+import (
+	"io/fs"
+
+	"github.com/go-rq/rq"
+)
+
 type Options struct {
 	Verbose bool
+	// Tags restricts the requests that are run to the ones tagged with at least one of the tags.
+	Tags []string
+	// EnvironmentName is the name of the environment of the environment files found
+	// next to the .http files that is used to run their requests.
+	EnvironmentName string
+	// StateFile is the path of the file the variables set by scripts are persisted to,
+	// see rq.StateStore.
+	StateFile string
+	// PersistSecrets writes the secret variables to the StateFile, see rq.WithPersistedSecrets.
+	PersistSecrets bool
+
+	// fsys is the file system the environment files are read from, the OS file system when nil.
+	fsys fs.FS
+	// environments caches the environment scope of each directory.
+	environments map[string]*rq.Environment
 }
 
 type Option func(*Options)
@@ -9,3 +30,44 @@ type Option func(*Options)
 func WithVerboseLogging(opts *Options) {
 	opts.Verbose = true
 }
+
+// WithTags only runs the requests tagged with at least one of the tags using a
+// `# @tag` annotation, the other requests are skipped.
+func WithTags(tags ...string) Option {
+	return func(opts *Options) {
+		opts.Tags = append(opts.Tags, tags...)
+	}
+}
+
+// WithEnvironmentName runs the requests in the environment name of the
+// http-client.env.json and http-client.private.env.json files found in the directory
+// of the .http files. The variables of the environment set in the context take
+// precedence over the ones of the files.
+func WithEnvironmentName(name string) Option {
+	return func(opts *Options) {
+		opts.EnvironmentName = name
+	}
+}
+
+// WithStateFile persists the variables set by scripts to the file at path, ex.,
+// testdata/.rq/state.json, so that the next runs reuse them. See rq.StateFile.
+func WithStateFile(path string) Option {
+	return func(opts *Options) {
+		opts.StateFile = path
+	}
+}
+
+// WithPersistedSecrets writes the secret variables set by scripts to the state file as
+// well, see rq.WithPersistedSecrets.
+func WithPersistedSecrets(opts *Options) {
+	opts.PersistSecrets = true
+}
+
+// withEnvironments shares the environments loaded while running the files of a
+// directory, so that the variables set by scripts are kept from one file to the next.
+func withEnvironments(fsys fs.FS, environments map[string]*rq.Environment) Option {
+	return func(opts *Options) {
+		opts.fsys = fsys
+		opts.environments = environments
+	}
+}
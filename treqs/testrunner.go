@@ -3,10 +3,13 @@ package treqs
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
@@ -21,19 +24,58 @@ var httpFileFilter = regexp.MustCompile(`^.*\.http$`)
 // Run runs all requests provided as argumentss. Each request is executed in a subtest with the
 // name of the request and each assertion result is marked as a pass or fail in the test output.
 // The requests are executed using the provided context. Environment variables or a shared runtime
-// can be provided via the context.
+// can be provided via the context. The templates of the requests can reference the responses of
+// the named requests run before them, see rq.History.
 func Run(t *testing.T, ctx context.Context, reqs []rq.Request, options ...Option) {
 	settings := Options{}
 	for _, option := range options {
 		option(&settings)
 	}
+	if settings.environments == nil {
+		settings.environments = map[string]*rq.Environment{}
+	}
+	if rq.GetHistory(ctx) == nil {
+		ctx = rq.WithHistory(ctx, rq.NewHistoryFor(reqs))
+	}
+	if settings.StateFile != "" {
+		var options []rq.StateStoreOption
+		if settings.PersistSecrets {
+			options = append(options, rq.WithPersistedSecrets())
+		}
+		store, err := rq.OpenStateStore(settings.StateFile, options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx = rq.WithStateStore(ctx, store)
+	}
 	for _, request := range reqs {
 		t.Run(request.DisplayName(), func(t *testing.T) {
+			if len(settings.Tags) > 0 && !request.HasTag(settings.Tags...) {
+				t.Skipf("request is not tagged with any of %v", settings.Tags)
+			}
+			ctx := ctx
+			if settings.EnvironmentName != "" {
+				env, err := settings.environment(ctx, request)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ctx = rq.WithEnvironmentScope(ctx, env)
+			}
 			if settings.Verbose {
-				ctx = rq.WithRequestRunner(ctx, httpClient(t))
+				ctx = rq.WithRequestRunner(ctx, httpClient(t, func(text string) string { return rq.Redact(ctx, text) }))
 			}
 			resp, err := request.Do(rq.WithLogger(ctx, t))
-			if err != nil {
+			var unresolved rq.UnresolvedVariablesError
+			switch {
+			case errors.As(err, &unresolved):
+				variables := make([]string, len(unresolved))
+				for i, variable := range unresolved {
+					variables[i] = variable.String()
+				}
+				t.Errorf("the request has unresolved variables:\n\t%s", strings.Join(variables, "\n\t"))
+			case errors.Is(err, rq.ErrSkipped):
+				// reported once the pre-request assertions are checked
+			case err != nil:
 				t.Error(err)
 			}
 			if len(request.PreRequestAssertions) > 0 {
@@ -52,6 +94,13 @@ func Run(t *testing.T, ctx context.Context, reqs []rq.Request, options ...Option
 					}
 				})
 			}
+			if errors.Is(err, rq.ErrSkipped) {
+				t.Skip("the request was skipped by its pre-request script")
+			}
+			if resp == nil {
+				// the request failed before a response was received
+				return
+			}
 			if len(resp.PostRequestAssertions) > 0 {
 				t.Run("Post-Request Assertions", func(t *testing.T) {
 					var failed bool
@@ -93,6 +142,7 @@ func RunDir(t *testing.T, ctx context.Context, path string, options ...Option) {
 		return nil
 	})
 
+	options = append(options, withEnvironments(nil, map[string]*rq.Environment{}))
 	for _, file := range files {
 		t.Run(filepath.Clean(file), func(t *testing.T) {
 			RunFile(t, ctx, file, options...)
@@ -100,14 +150,86 @@ func RunDir(t *testing.T, ctx context.Context, path string, options ...Option) {
 	}
 }
 
+// RunFS runs all requests from all files with a .http extension found recursively in the directory
+// root of fsys. Script files referenced by the requests are resolved in fsys, which allows running
+// requests embedded in the test binary with go:embed.
+func RunFS(t *testing.T, ctx context.Context, fsys fs.FS, root string, options ...Option) {
+	var files []string
+	fs.WalkDir(fsys, root, func(filePath string, _ fs.DirEntry, _ error) error {
+		if httpFileFilter.MatchString(filePath) {
+			files = append(files, filePath)
+		}
+		return nil
+	})
+
+	options = append(options, withEnvironments(fsys, map[string]*rq.Environment{}))
+	for _, file := range files {
+		t.Run(file, func(t *testing.T) {
+			requests, err := rq.ParseFS(fsys, file)
+			if err != nil {
+				t.Error(err)
+				t.FailNow()
+			}
+			Run(t, ctx, requests, options...)
+		})
+	}
+}
+
+// environment returns the scope of the environment of the context for the directory of
+// the request, holding the variables of the selected environment of the environment
+// files that are not set in the environment of the context.
+func (o *Options) environment(ctx context.Context, request rq.Request) (*rq.Environment, error) {
+	dir := filepath.Dir(request.File)
+	if o.fsys != nil {
+		dir = path.Dir(request.File)
+	}
+	if env, ok := o.environments[dir]; ok {
+		return env, nil
+	}
+	var environments rq.Environments
+	var err error
+	if o.fsys != nil {
+		environments, err = rq.LoadEnvironmentsFS(o.fsys, dir)
+	} else {
+		environments, err = rq.LoadEnvironments(dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	variables, ok := environments.Get(o.EnvironmentName)
+	if !ok {
+		return nil, fmt.Errorf("environment %q is not defined in the environment files of %s", o.EnvironmentName, dir)
+	}
+	global := rq.GetEnvironment(ctx)
+	env := global.Scope()
+	secrets := map[string]bool{}
+	for _, key := range environments.SecretKeys(o.EnvironmentName) {
+		secrets[key] = true
+	}
+	for key, value := range variables {
+		if _, ok := global.Get(key); ok {
+			continue
+		}
+		if secrets[key] {
+			env.SetSecret(key, value)
+		} else {
+			env.Set(key, value)
+		}
+	}
+	o.environments[dir] = env
+	return env, nil
+}
+
 type roundtripper struct {
 	proxied http.RoundTripper
 	t       *testing.T
+	// redact masks the secret values of the request in the logs.
+	redact func(text string) string
 }
 
-func httpClient(t *testing.T) *http.Client {
+func httpClient(t *testing.T, redact func(text string) string) *http.Client {
 	return &http.Client{
-		Transport:     &roundtripper{proxied: http.DefaultTransport, t: t},
+		Transport:     &roundtripper{proxied: http.DefaultTransport, t: t, redact: redact},
 		CheckRedirect: http.DefaultClient.CheckRedirect,
 		Jar:           http.DefaultClient.Jar,
 		Timeout:       http.DefaultClient.Timeout,
@@ -120,7 +242,7 @@ func (r *roundtripper) RoundTrip(request *http.Request) (*http.Response, error)
 	builder.WriteString("\n----- Request\n")
 	builder.WriteString(fmt.Sprintf("%s %s\n", request.Method, request.URL.String()))
 	for k, v := range request.Header {
-		builder.WriteString(fmt.Sprintf("%s: %s\n", k, v[0]))
+		builder.WriteString(fmt.Sprintf("%s: %s\n", k, rq.RedactHeader(k, v[0])))
 	}
 	if request.Body != nil {
 		builder.WriteString("\n")
@@ -130,7 +252,7 @@ func (r *roundtripper) RoundTrip(request *http.Request) (*http.Response, error)
 		builder.WriteString(string(body))
 	}
 
-	r.t.Log(builder.String())
+	r.t.Log(r.redact(builder.String()))
 	builder.Reset()
 	// run the request
 	start := time.Now() // start a timer
@@ -143,13 +265,20 @@ func (r *roundtripper) RoundTrip(request *http.Request) (*http.Response, error)
 	if err != nil {
 		return nil, err
 	}
-	// reset the response body to be read, then set it again to a new
-	// reader afterwards so it's available by callers
-	resp.Body = io.NopCloser(bytes.NewBuffer(b))
+	// log a copy of the response with the sensitive headers masked, its body is a new
+	// reader so that the body remains available to callers
+	logged := *resp
+	logged.Header = resp.Header.Clone()
+	for key, values := range logged.Header {
+		for i, value := range values {
+			values[i] = rq.RedactHeader(key, value)
+		}
+	}
+	logged.Body = io.NopCloser(bytes.NewBuffer(b))
 	buf := bytes.NewBuffer(nil)
-	resp.Write(buf)
+	logged.Write(buf)
 	builder.WriteString(buf.String())
 	resp.Body = io.NopCloser(bytes.NewBuffer(b))
-	r.t.Log(builder.String())
+	r.t.Log(r.redact(builder.String()))
 	return resp, err
 }
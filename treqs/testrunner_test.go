@@ -4,10 +4,16 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+	"testing/fstest"
 
 	"github.com/go-rq/rq"
 	"github.com/go-rq/rq/treqs"
+	"github.com/google/go-cmp/cmp"
 )
 
 func TestTreqs(t *testing.T) {
@@ -22,3 +28,189 @@ func TestTreqs(t *testing.T) {
 		"host": srv.URL,
 	}), "../testdata", treqs.WithVerboseLogging)
 }
+
+func TestTreqs_RunFS(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/foo", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"foo": "bar"}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	treqs.RunFS(t, rq.WithEnvironment(context.Background(), map[string]string{
+		"host": srv.URL,
+	}), os.DirFS("../testdata"), ".")
+}
+
+func TestTreqs_WithTags(t *testing.T) {
+	var calls []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.URL.Path)
+	}))
+	defer srv.Close()
+	requests, err := rq.ParseRequests(`### Health
+# @tag smoke
+GET {{host}}/health
+
+### Users
+# @tag regression
+GET {{host}}/users
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	treqs.Run(t, rq.WithEnvironment(context.Background(), map[string]string{
+		"host": srv.URL,
+	}), requests, treqs.WithTags("smoke"))
+	if len(calls) != 1 || calls[0] != "/health" {
+		t.Errorf("expected only the smoke request to run, got %v", calls)
+	}
+}
+
+func TestTreqs_WithEnvironmentName(t *testing.T) {
+	var calls []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.URL.Path+" "+r.Header.Get("Authorization"))
+	}))
+	defer srv.Close()
+	files := map[string]string{
+		"api/users.http":                   "GET {{host}}/{{version}}/users\nAuthorization: {{token}}\n",
+		"api/" + rq.EnvironmentFile:        `{"dev": {"version": "v0"}, "staging": {"version": "v2"}}`,
+		"api/" + rq.PrivateEnvironmentFile: `{"staging": {"token": "secret"}}`,
+	}
+	dir := t.TempDir()
+	fsys := fstest.MapFS{}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		fsys[name] = &fstest.MapFile{Data: []byte(content)}
+	}
+	ctx := rq.WithEnvironment(context.Background(), map[string]string{"host": srv.URL})
+
+	treqs.RunDir(t, ctx, dir, treqs.WithEnvironmentName("staging"))
+	treqs.RunFS(t, ctx, fsys, ".", treqs.WithEnvironmentName("staging"))
+	if diff := cmp.Diff([]string{"/v2/users secret", "/v2/users secret"}, calls); diff != "" {
+		t.Errorf("calls mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestTreqs_responseReferences(t *testing.T) {
+	var calls []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.URL.Path+" "+r.Header.Get("Authorization"))
+		if r.URL.Path == "/login" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"token": "abc"}`))
+		}
+	}))
+	defer srv.Close()
+	requests, err := rq.ParseRequests(`### login
+POST {{host}}/login
+
+### Users
+GET {{host}}/users
+Authorization: Bearer {{login.response.body.$.token}}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	treqs.Run(t, rq.WithEnvironment(context.Background(), map[string]string{"host": srv.URL}), requests)
+	if diff := cmp.Diff([]string{"/login ", "/users Bearer abc"}, calls); diff != "" {
+		t.Errorf("calls mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestTreqs_WithStateFile(t *testing.T) {
+	var calls []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.URL.Path+" "+r.Header.Get("Authorization"))
+	}))
+	defer srv.Close()
+	requests, err := rq.ParseRequests(`### Login
+< {% if (getEnv('token')) { request.skip = true } %}
+POST {{host}}/login
+
+< {% setEnv('token', 'abc', '1h') %}
+
+### Users
+GET {{host}}/users
+Authorization: Bearer {{token}}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := filepath.Join(t.TempDir(), rq.StateFile)
+	ctx := rq.WithEnvironment(context.Background(), map[string]string{"host": srv.URL})
+	treqs.Run(t, ctx, requests[1:], treqs.WithStateFile(state))
+	treqs.Run(t, ctx, requests, treqs.WithStateFile(state))
+	treqs.Run(t, ctx, requests, treqs.WithStateFile(state))
+	if diff := cmp.Diff([]string{"/users Bearer {{token}}", "/login ", "/users Bearer abc", "/users Bearer abc"}, calls); diff != "" {
+		t.Errorf("calls mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestTreqs_strictVariables(t *testing.T) {
+	if os.Getenv("TREQS_STRICT_VARIABLES") == "1" {
+		requests, err := rq.ParseRequests("### Users\nGET {{host}}/users\nAuthorization: {{token}}\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		treqs.Run(t, rq.WithStrictVariables(context.Background()), requests)
+		return
+	}
+	// the failure is asserted from the output of the test run in a subprocess
+	cmd := exec.Command(os.Args[0], "-test.run=^TestTreqs_strictVariables$", "-test.v")
+	cmd.Env = append(os.Environ(), "TREQS_STRICT_VARIABLES=1")
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("expected the test to fail:\n%s", output)
+	}
+	for _, expected := range []string{
+		"the request has unresolved variables:",
+		"{{host}} in URL",
+		"{{token}} in header value Authorization",
+	} {
+		if !strings.Contains(string(output), expected) {
+			t.Errorf("expected %q in the output:\n%s", expected, output)
+		}
+	}
+	if strings.Contains(string(output), "panic") {
+		t.Errorf("unexpected panic:\n%s", output)
+	}
+}
+
+func TestTreqs_verboseLoggingRedactsSecrets(t *testing.T) {
+	if os.Getenv("TREQS_VERBOSE_SECRETS") == "1" {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "cookie-value"})
+			w.Write([]byte(`{"key": "` + r.Header.Get("X-Api-Key") + `"}`))
+		}))
+		defer srv.Close()
+		requests, err := rq.ParseRequests("### Users\nGET {{host}}/users\nAuthorization: Bearer bearer-value\nX-Api-Key: {{key}}\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := rq.WithEnvironment(context.Background(), map[string]string{"host": srv.URL, "key": "secret:key-value"})
+		treqs.Run(t, ctx, requests, treqs.WithVerboseLogging)
+		return
+	}
+	// the logs are asserted from the output of the test run in a subprocess
+	cmd := exec.Command(os.Args[0], "-test.run=^TestTreqs_verboseLoggingRedactsSecrets$", "-test.v")
+	cmd.Env = append(os.Environ(), "TREQS_VERBOSE_SECRETS=1")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("unexpected failure: %v\n%s", err, output)
+	}
+	for _, secret := range []string{"bearer-value", "cookie-value", "key-value"} {
+		if strings.Contains(string(output), secret) {
+			t.Errorf("expected %q to be masked in the output:\n%s", secret, output)
+		}
+	}
+	if !strings.Contains(string(output), "Authorization: Bearer ****") {
+		t.Errorf("expected the Authorization header to be masked in the output:\n%s", output)
+	}
+}